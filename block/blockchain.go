@@ -1,54 +1,94 @@
 package block
 
 import (
-	"bytes"
+	"context"
 	"crypto/ecdsa"
-	"crypto/sha256"
-	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"goblockchain/utils"
+	"goblockchain/wallet"
 	"log"
-	"net/http"
+	"runtime"
 	"strings"
 	"sync"
 	"time"
 )
 
 const (
-	MINING_DIFICULTY = 3
-	MINING_SENDER    = "THE BLOCKCHAIN"
-	MINING_REWARD    = 1.0
-	MINING_TIMER_SEC = 100
-	BLOCKCHAIN_PORT_RANGE_START = 5001
-	BLOCKCHAIN_PORT_RANGE_END = 5004
-	NEIGHBOR_IP_RANGE_START = 0
-	NEIGHBOR_IP_RANGE_END = 1
+	MINING_DIFICULTY_BITS             = 0x1f0fffff
+	POW_LIMIT_BITS                    = 0x2000ffff
+	BLOCK_INTERVAL_SEC                = MINING_TIMER_SEC
+	RETARGET_WINDOW                   = 10
+	MEDIAN_TIME_SPAN                  = 11
+	MAX_FUTURE_DRIFT_SEC              = 2 * 60 * 60
+	MINING_SENDER                     = "THE BLOCKCHAIN"
+	MINING_REWARD                     = 100000000
+	HALVING_INTERVAL                  = 210000
+	MAX_SUPPLY                        = 2 * MINING_REWARD * HALVING_INTERVAL
+	AMOUNT_DECIMALS                   = 8
+	MAX_BLOCK_SIZE                    = 1000000
+	MAX_BLOCK_TRANSACTIONS            = 2000
+	MINING_TIMER_SEC                  = 100
+	BLOCKCHAIN_PORT_RANGE_START       = 5001
+	BLOCKCHAIN_PORT_RANGE_END         = 5004
+	NEIGHBOR_IP_RANGE_START           = 0
+	NEIGHBOR_IP_RANGE_END             = 1
 	BLOCKCHAIN_NEIGHBOR_SYNC_TIME_SEC = 20
 )
 
+var (
+	ErrInvalidSignature     = errors.New("invalid transaction signature")
+	ErrInvalidValue         = errors.New("transaction value must be positive")
+	ErrInsufficientBalance  = errors.New("insufficient balance")
+	ErrSenderMismatch       = errors.New("sender address does not match public key")
+	ErrInvalidRecipient     = errors.New("invalid recipient address")
+	ErrDuplicateTransaction = errors.New("transaction already known")
+	ErrNonceTooLow          = errors.New("nonce already used")
+	ErrNonceGap             = errors.New("nonce is ahead of the next expected nonce")
+	ErrInvalidCoinbase      = errors.New("invalid coinbase transaction")
+	ErrBlockTooLarge        = errors.New("block exceeds the size limit")
+	ErrTooManyTransactions  = errors.New("block exceeds the transaction limit")
+	ErrStateRootMismatch    = errors.New("state root mismatch")
+	ErrGenesisMismatch      = errors.New("genesis block does not match the chain params")
+	ErrTimestampTooOld      = errors.New("block timestamp is not after the median time past")
+	ErrTimestampTooNew      = errors.New("block timestamp is too far in the future")
+)
+
 type Block struct {
-	timestamp    int64
-	nonce        int
-	previousHash [32]byte
+	header       BlockHeader
 	transactions []*Transaction
 }
 
-func NewBlock(nonce int, previousHash [32]byte, transactions []*Transaction) *Block {
+// NewBlock builds the block at height on top of previousHash, dated
+// timestamp in nanoseconds, committing to transactions through the
+// header's merkle root. The nonce is left for ProofOfWork to find.
+func NewBlock(height uint64, previousHash [32]byte, timestamp int64, transactions []*Transaction, bits uint32) *Block {
 	b := new(Block)
-	b.timestamp = time.Now().UnixNano()
-	b.nonce = nonce
-	b.previousHash = previousHash
+	b.header.version = BLOCK_VERSION
+	b.header.height = height
+	b.header.previousHash = previousHash
+	b.header.merkleRoot = MerkleRoot(transactionIDs(transactions))
+	b.header.timestamp = timestamp
+	b.header.bits = bits
 	b.transactions = transactions
 	return b
 }
 
-func (b *Block) Nonce() int {
-	return b.nonce
+func (b *Block) Header() *BlockHeader {
+	return &b.header
+}
+
+func (b *Block) Height() uint64 {
+	return b.header.height
+}
+
+func (b *Block) Nonce() uint64 {
+	return b.header.nonce
 }
 
 func (b *Block) PreviuosHash() [32]byte {
-	return b.previousHash
+	return b.header.previousHash
 }
 
 func (b *Block) Transaction() []*Transaction {
@@ -56,97 +96,214 @@ func (b *Block) Transaction() []*Transaction {
 }
 
 func (b *Block) Print() {
-	fmt.Printf("timestamp 		%d\n", b.timestamp)
-	fmt.Printf("nonce 			%d\n", b.nonce)
-	fmt.Printf("previousHash 	%x\n", b.previousHash)
+	fmt.Printf("height 			%d\n", b.header.height)
+	fmt.Printf("timestamp 		%d\n", b.header.timestamp)
+	fmt.Printf("bits 			%08x\n", b.header.bits)
+	fmt.Printf("nonce 			%d\n", b.header.nonce)
+	fmt.Printf("previousHash 	%x\n", b.header.previousHash)
+	fmt.Printf("merkleRoot 		%x\n", b.header.merkleRoot)
 	for _, t := range b.transactions {
 		t.Print()
 	}
 }
 
+// Size is the encoded size of the block that the block size limit counts:
+// the binary header plus every transaction.
+func (b *Block) Size() uint64 {
+	size := uint64(HEADER_SIZE)
+	for _, t := range b.transactions {
+		size += t.Size()
+	}
+	return size
+}
+
+// Hash identifies the block by its header alone.
 func (b *Block) Hash() [32]byte {
-	m, _ := json.Marshal(b)
-	return sha256.Sum256([]byte(m))
+	return b.header.Hash()
 }
 
 func (b *Block) MarshalJSON() ([]byte, error) {
 	return json.Marshal(struct {
-		Timestamp    int64          `json:"timestamp"`
-		Nonce        int            `json:"nonce"`
-		PreviuosHash string         `json:"previous_hash"`
+		Header       *BlockHeader   `json:"header"`
 		Transactions []*Transaction `json:"transactions"`
 	}{
-		Timestamp:    b.timestamp,
-		Nonce:        b.nonce,
-		PreviuosHash: fmt.Sprintf("%x", b.previousHash),
+		Header:       &b.header,
 		Transactions: b.transactions,
 	})
 }
 
 func (b *Block) UnmarshalJSON(data []byte) error {
-	var previousHash string
 	v := &struct{
-		Timestamp    *int64          `json:"timestamp"`
-		Nonce        *int            `json:nonce`
-		PreviousHash *string         `json:"previous_hash"`
+		Header       *BlockHeader    `json:"header"`
 		Transactions *[]*Transaction `json:"transactions"`
 	}{
-		Timestamp: &b.timestamp,
-		Nonce: &b.nonce,
-		PreviousHash: &previousHash,
+		Header:       &b.header,
 		Transactions: &b.transactions,
 	}
 	if err := json.Unmarshal(data, &v); err != nil {
 		return err
 	}
-	ph, _ := hex.DecodeString(*v.PreviousHash)
-	copy(b.previousHash[:], ph[:32])
 	return nil
 }
 
 type BlockChain struct {
-	transactionPool  []*Transaction
+	transactionPool  *mempool
+	orphans          *orphanPool
+	seenBlocks       *seenSet
+	seenTransactions *seenSet
+	fetchingBlocks   *seenSet
+	minRelayFee      utils.Amount
+	state            *chainState
+	undo             []*stateUndo
 	chain            []*Block
 	blockhainAddress string
 	port			 uint16
 	mux              sync.Mutex
 	neighbors 		 []string
 	muxNeighbors     sync.Mutex
+	store            Store
+	params           *ChainParams
+	genesis          *Block
+	clock            func() time.Time
+	lastSyncTrigger  time.Time
+	reorgHandlers    []func(*ReorgEvent)
+	muxMining        sync.Mutex
+	miningCancel     context.CancelFunc
+	miningPoolSize   int
+	miningWorkers    int32
+	hashrate         uint64
+	totalHashes      uint64
+}
+
+// CreateBlock appends a mined block to the chain, connects it to the
+// state index and drops its transactions from the pool.
+func (bc *BlockChain) CreateBlock(b *Block) *Block {
+	if err := bc.appendBlock(b); err != nil {
+		log.Printf("ERROR: %v", err)
+		return nil
+	}
+	return b
 }
 
-func (bc *BlockChain) CreateBlock(nonce int, previousHash [32]byte) *Block {
-	b := NewBlock(nonce, previousHash, bc.transactionPool)
-	bc.chain = append(bc.chain, b)
-	bc.transactionPool = []*Transaction{}
-	for _, n := range bc.neighbors {
-		endpoint := fmt.Sprintf("http://%s/transactions", n)
-		client := &http.Client{}
-		req, _ := http.NewRequest("DELETE", endpoint, nil)
-		resp, _ := client.Do(req)
-		log.Printf("%v", resp)
+// appendBlock validates b as the successor of the tip and connects it to
+// the state index, the store and the chain.
+func (bc *BlockChain) appendBlock(b *Block) error {
+	height := len(bc.chain)
+	if err := bc.validHeader(headersOf(bc.chain), b.Header()); err != nil {
+		return err
 	}
-	return b
+	if err := validBlockStructure(b, height, bc.params); err != nil {
+		return err
+	}
+	undo, err := bc.state.connectBlock(height, b)
+	if err != nil {
+		return err
+	}
+	if err := bc.store.Append(b); err != nil {
+		bc.state.disconnectBlock(undo)
+		return err
+	}
+	bc.chain = append(bc.chain, b)
+	bc.undo = append(bc.undo, undo)
+	bc.removeFromPool(b.transactions)
+	return nil
 }
 
-func NewBlockchain(blockhainAddress string, port uint16) *BlockChain {
-	b := &Block{}
+// NewBlockchain loads the chain kept in store, creating the genesis block
+// when the store is empty. A stored chain that fails validation is an error.
+func NewBlockchain(blockhainAddress string, port uint16, store Store, params *ChainParams) (*BlockChain, error) {
+	if err := params.Validate(); err != nil {
+		return nil, err
+	}
+	genesis, err := params.GenesisBlock()
+	if err != nil {
+		return nil, err
+	}
 	bc := new(BlockChain)
+	bc.params = params
+	bc.genesis = genesis
+	bc.clock = time.Now
 	bc.blockhainAddress = blockhainAddress
-	bc.CreateBlock(0, b.Hash())
 	bc.port = port
-	return bc
+	bc.store = store
+	bc.transactionPool = newMempool(MEMPOOL_MAX_SIZE, params.Ledger == LEDGER_UTXO)
+	bc.orphans = newOrphanPool(MAX_ORPHAN_BLOCKS)
+	bc.seenBlocks = newSeenSet(MAX_SEEN_BLOCKS)
+	bc.seenTransactions = newSeenSet(MAX_SEEN_TRANSACTIONS)
+	bc.fetchingBlocks = newSeenSet(MAX_SEEN_BLOCKS)
+	bc.state = newChainState(params)
+	bc.minRelayFee = DEFAULT_MIN_RELAY_FEE_RATE
+	bc.SetMiningWorkers(runtime.NumCPU())
+	if err := bc.load(); err != nil {
+		return nil, err
+	}
+	return bc, nil
+}
+
+func (bc *BlockChain) load() error {
+	if bc.store.Len() == 0 {
+		if bc.CreateBlock(bc.genesis) == nil {
+			return errors.New("failed to store genesis block")
+		}
+		return nil
+	}
+	chain := make([]*Block, 0, bc.store.Len())
+	err := bc.store.Iterate(func(_ int, b *Block) bool {
+		chain = append(chain, b)
+		return true
+	})
+	if err != nil {
+		return err
+	}
+	state, undo, err := bc.replayChain(chain)
+	if err != nil {
+		return fmt.Errorf("stored chain is invalid: %w", err)
+	}
+	bc.chain = chain
+	bc.state = state
+	bc.undo = undo
+	log.Printf("action=load_chain, network=%s, genesis=%x, blocks=%d", bc.params.NetworkID, bc.genesis.Hash(), len(chain))
+	return nil
 }
 
+
+// SetNeighbors scans the neighbor range of the chain params and keeps the
+// nodes that run the same genesis block. The peers are asked without
+// holding muxNeighbors, which only guards the switch to the new list.
 func (bc *BlockChain) SetNeighbors() {
-	bc.neighbors = utils.FindNeighbors("127.0.0.1", bc.port, NEIGHBOR_IP_RANGE_START, NEIGHBOR_IP_RANGE_END, BLOCKCHAIN_PORT_RANGE_START, BLOCKCHAIN_PORT_RANGE_END)
-	log.Printf("%v", bc.neighbors)
+	p := bc.params
+	found := utils.FindNeighbors("127.0.0.1", bc.port, p.NeighborIPRangeStart, p.NeighborIPRangeEnd, p.PortRangeStart, p.PortRangeEnd)
+	neighbors := make([]string, 0, len(found))
+	for _, n := range found {
+		if err := bc.checkPeerGenesis(n); err != nil {
+			log.Printf("action=set_neighbors, peer=%s, status=ignored, reason=%v", n, err)
+			continue
+		}
+		neighbors = append(neighbors, n)
+	}
+	bc.setNeighbors(neighbors)
+	log.Printf("%v", neighbors)
+}
+
+func (bc *BlockChain) setNeighbors(neighbors []string) {
+	bc.muxNeighbors.Lock()
+	defer bc.muxNeighbors.Unlock()
+	bc.neighbors = neighbors
+}
+
+// Neighbors returns the current neighbors. The list is replaced, never
+// changed in place, so the caller may keep it.
+func (bc *BlockChain) Neighbors() []string {
+	bc.muxNeighbors.Lock()
+	defer bc.muxNeighbors.Unlock()
+	return bc.neighbors
 }
 
 func(bc *BlockChain) MarshalJSON() ([]byte, error) {
 	return json.Marshal(struct {
 		Blocks []*Block `json:"chain"`
 	}{
-		Blocks: bc.chain,
+		Blocks: bc.Chain(),
 	})
 }
 
@@ -163,8 +320,6 @@ func (bc *BlockChain) UnmarshalJSON(data []byte) error {
 }
 
 func (bc *BlockChain) SyncNeighbors(){
-	bc.muxNeighbors.Lock()
-	defer bc.muxNeighbors.Unlock()
 	bc.SetNeighbors()
 }
 
@@ -173,7 +328,38 @@ func (bc *BlockChain) StartSyncNeighbors(){
 	_ = time.AfterFunc(time.Second * BLOCKCHAIN_NEIGHBOR_SYNC_TIME_SEC, bc.StartSyncNeighbors)
 }
 
+func (bc *BlockChain) Params() *ChainParams {
+	return bc.params
+}
+
+// ParseAmount reads a decimal coin amount as given over HTTP.
+func (bc *BlockChain) ParseAmount(s string) (utils.Amount, error) {
+	return utils.ParseAmount(s, bc.params.Decimals)
+}
+
+func (bc *BlockChain) FormatAmount(a utils.Amount) string {
+	return utils.FormatAmount(a, bc.params.Decimals)
+}
+
+// SetClock replaces the source of the current time, which stamps new
+// blocks and bounds how far ahead of it a block may be dated.
+func (bc *BlockChain) SetClock(now func() time.Time) {
+	bc.mux.Lock()
+	defer bc.mux.Unlock()
+	bc.clock = now
+}
+
+// GenesisHash identifies the network the chain belongs to.
+func (bc *BlockChain) GenesisHash() [32]byte {
+	return bc.genesis.Hash()
+}
+
+// Chain is the current chain. Blocks are never changed in place and the
+// chain is only ever extended or replaced, so the slice stays valid after
+// the lock is released.
 func (bc *BlockChain) Chain() []*Block {
+	bc.mux.Lock()
+	defer bc.mux.Unlock()
 	return bc.chain
 }
 
@@ -183,11 +369,66 @@ func (bc *BlockChain) Run(){
 }
 
 func (bc *BlockChain) TransactionPool() []*Transaction {
-	return bc.transactionPool
+	bc.mux.Lock()
+	defer bc.mux.Unlock()
+	return bc.CopyTransactionPool()
+}
+
+// removeFromPool drops the transactions a connected block included and
+// rechecks the pending ones it may have invalidated: those of a sender it
+// spent from, whose nonce or balance changed, and those spending an output
+// it spent. The rest are still valid on top of the new tip.
+func (bc *BlockChain) removeFromPool(transactions []*Transaction) {
+	included := make(map[[32]byte]bool, len(transactions))
+	senders := make(map[string]bool)
+	spent := make(map[utils.OutPoint]bool)
+	for _, t := range transactions {
+		included[t.ID()] = true
+		if !t.coinbase {
+			senders[t.senderBlockchainAddress] = true
+		}
+		for _, o := range t.inputs {
+			spent[o] = true
+		}
+	}
+	bc.transactionPool.remove(included)
+
+	stale := make(map[[32]byte]bool)
+	recheck := make([]*Transaction, 0)
+	for _, t := range bc.transactionPool.transactions() {
+		touched := senders[t.senderBlockchainAddress]
+		for _, o := range t.inputs {
+			touched = touched || spent[o]
+		}
+		if touched {
+			stale[t.ID()] = true
+			recheck = append(recheck, t)
+		}
+	}
+	if len(recheck) == 0 {
+		return
+	}
+	bc.transactionPool.remove(stale)
+	bc.readmitToPool(recheck)
 }
 
-func (bc *BlockChain) ClearTransactionPool() {
-	bc.transactionPool = bc.transactionPool[:0]
+// revalidatePool offers transactions and then everything pending back to
+// the pool in order, keeping what is still valid on top of the tip.
+func (bc *BlockChain) revalidatePool(transactions []*Transaction) {
+	pending := append(transactions, bc.transactionPool.transactions()...)
+	bc.transactionPool.clear()
+	bc.readmitToPool(pending)
+}
+
+// readmitToPool admits transactions again after the chain changed under
+// them. Each was verified when it entered a block or the pool, so its
+// signature is not checked again.
+func (bc *BlockChain) readmitToPool(transactions []*Transaction) {
+	for _, t := range transactions {
+		if err := bc.admitToPool(t); err != nil {
+			log.Printf("action=revalidate_pool, dropped_transaction=%x, reason=%v", t.ID(), err)
+		}
+	}
 }
 
 func (bc *BlockChain) Print() {
@@ -197,263 +438,371 @@ func (bc *BlockChain) Print() {
 	}
 }
 
+// LastBlock is the tip. The caller must hold bc.mux.
 func (bc *BlockChain) LastBlock() *Block {
 	return bc.chain[len(bc.chain)-1]
 }
 
-func (bc *BlockChain) CreateTransaction(sender string, recipient string, value float32,
-	senderPublicKey *ecdsa.PublicKey, s *utils.Signature) bool {
-	isTransacted := bc.AddTransaction(sender, recipient, value, senderPublicKey, s)
-	
-	if isTransacted {
-		for _, n := range bc.neighbors {
-			publicKeyStr := fmt.Sprintf("%064x%064x", senderPublicKey.X.Bytes(), senderPublicKey.Y.Bytes())
-			signatureStr := s.String()
-			bt := &TransactionRequest{
-				&sender, &recipient, &publicKeyStr, &value, &signatureStr}
-			m, _ := json.Marshal(bt)
-			buf := bytes.NewBuffer(m)
-			endpoint := fmt.Sprintf("http://%s/transactions", n)
-			client := &http.Client{}
-			req, _ := http.NewRequest("PUT", endpoint, buf)
-			resp, _ := client.Do(req)
-			log.Printf("%v", resp)
-		}
-	}
+// AddTransaction submits a transaction created on this node, which is
+// admitted and gossiped like any other through ReceiveTransaction.
+func (bc *BlockChain) AddTransaction(sender string, recipient string, value utils.Amount, fee utils.Amount, nonce uint64,
+	senderPublicKey *ecdsa.PublicKey, s *utils.Signature) error {
+	return bc.ReceiveTransaction(NewTransaction(sender, recipient, value, fee, nonce, senderPublicKey, s), TX_RELAY_TTL, "")
+}
 
-	return isTransacted
+// AddUTXOTransaction is AddTransaction for chains on the UTXO ledger.
+func (bc *BlockChain) AddUTXOTransaction(sender string, inputs []utils.OutPoint, outputs []utils.TxOutput, fee utils.Amount,
+	senderPublicKey *ecdsa.PublicKey, s *utils.Signature) error {
+	return bc.ReceiveTransaction(NewUTXOTransaction(sender, inputs, outputs, fee, senderPublicKey, s), TX_RELAY_TTL, "")
 }
 
-func (bc *BlockChain) AddTransaction(sender string, recipient string, value float32,
-	senderPublicKey *ecdsa.PublicKey, s *utils.Signature) bool {
-	t := NewTransaction(sender, recipient, value)
+// addToPool admits t to the transaction pool if it is valid on top of the
+// current chain and pool and pays at least the minimum relay fee. When the
+// pool is full, t replaces the cheapest evictable entry if it pays a higher
+// fee rate.
+func (bc *BlockChain) addToPool(t *Transaction) error {
+	if err := bc.checkTransaction(t); err != nil {
+		return err
+	}
+	return bc.admitToPool(t)
+}
 
-	if sender == MINING_SENDER {
-		bc.transactionPool = append(bc.transactionPool, t)
-		return true
+// checkTransaction runs the checks on t that do not depend on the chain or
+// the pool, the signature among them.
+func (bc *BlockChain) checkTransaction(t *Transaction) error {
+	if t.coinbase || t.senderBlockchainAddress == MINING_SENDER {
+		return fmt.Errorf("%w: only miners create it", ErrInvalidCoinbase)
 	}
-	if bc.VerifyTransactionSignature(senderPublicKey, s, t) {
-		// if bc.CalculateTotalAmount(sender) < value {
-		// 	log.Println("ERROR: Not enough balance in a wallet")
-		// 	return false
-		// }
-		bc.transactionPool = append(bc.transactionPool, t)
-		return true
-	} else {
+	if !bc.usesUTXO() {
+		if len(t.inputs) > 0 || len(t.outputs) > 0 {
+			return fmt.Errorf("%w: account transactions carry no inputs or outputs", ErrWrongLedger)
+		}
+		if t.value <= 0 {
+			return ErrInvalidValue
+		}
+		if err := wallet.ValidateAddress(t.recipientBlockchainAddress); err != nil {
+			return fmt.Errorf("%w: %v", ErrInvalidRecipient, err)
+		}
+	}
+	if t.senderPublicKey == nil || t.signature == nil || !bc.VerifyTransactionSignature(t.senderPublicKey, t.signature, t) {
 		log.Println("ERROR: Verify Transaction")
+		return ErrInvalidSignature
 	}
-	return false
-}
-
-func (bc *BlockChain) VerifyTransactionSignature(
-	senderPublicKey *ecdsa.PublicKey, s *utils.Signature, t *Transaction) bool {
-	m, _ := json.Marshal(t)
-	h := sha256.Sum256([]byte(m))
-	return ecdsa.Verify(senderPublicKey, h[:], s.R, s.S)
+	if wallet.AddressFromPublicKey(t.senderPublicKey) != t.senderBlockchainAddress {
+		log.Println("ERROR: Sender address does not belong to public key")
+		return ErrSenderMismatch
+	}
+	return nil
 }
 
-func (bc *BlockChain) CopyTransactionPool() []*Transaction {
-	transactions := make([]*Transaction, 0)
-	for _, t := range bc.transactionPool {
-		transactions = append(transactions,
-			NewTransaction(t.senderBlockchainAddress, t.recipientBlockchainAddress, t.value))
+// admitToPool is addToPool for a transaction that passed checkTransaction.
+func (bc *BlockChain) admitToPool(t *Transaction) error {
+	sender, value, nonce := t.senderBlockchainAddress, t.value, t.nonce
+	if bc.transactionPool.Has(t.ID()) {
+		return ErrDuplicateTransaction
 	}
-	return transactions
+	if bc.usesUTXO() {
+		for _, o := range t.inputs {
+			if bc.transactionPool.spends(o) {
+				return fmt.Errorf("%w: %s by a pending transaction", ErrDoubleSpend, o)
+			}
+		}
+		if err := checkUTXOTransaction(t, bc.state.output); err != nil {
+			return err
+		}
+	} else if next := bc.nextNonce(sender); nonce < next {
+		return fmt.Errorf("%w: got %d, expected %d", ErrNonceTooLow, nonce, next)
+	} else if nonce > next {
+		return fmt.Errorf("%w: got %d, expected %d", ErrNonceGap, nonce, next)
+	}
+	entry := newPoolEntry(t)
+	if required, err := minFee(bc.minRelayFee, entry.size); err != nil || t.fee < required {
+		return fmt.Errorf("%w: got %s, need %s for %d bytes", ErrFeeTooLow,
+			bc.FormatAmount(t.fee), bc.FormatAmount(required), entry.size)
+	}
+	if !bc.usesUTXO() {
+		cost, err := t.Cost()
+		if err != nil {
+			return err
+		}
+		available, err := bc.AvailableAmount(sender)
+		if err != nil {
+			return err
+		}
+		if available < cost {
+			log.Printf("ERROR: Not enough balance in a wallet, available=%d, value=%d, fee=%d", available, value, t.fee)
+			return fmt.Errorf("%w: available %s, requested %s", ErrInsufficientBalance,
+				bc.FormatAmount(available), bc.FormatAmount(cost))
+		}
+	}
+	if bc.transactionPool.full() {
+		victim := bc.transactionPool.evictionCandidate(sender)
+		if victim == nil || !feeRateLess(victim, entry) {
+			return ErrMempoolFull
+		}
+		bc.transactionPool.remove(map[[32]byte]bool{victim.tx.ID(): true})
+		log.Printf("action=mempool, status=evict, transaction=%x, fee=%d, size=%d", victim.tx.ID(), victim.tx.fee, victim.size)
+	}
+	bc.transactionPool.add(entry)
+	return nil
 }
 
-func (bc *BlockChain) ValidProof(nonce int, previousHash [32]byte, transactions []*Transaction, difficulty int) bool {
-	zeroes := strings.Repeat("0", difficulty)
-	guessBlock := Block{0, nonce, previousHash, transactions}
-	guessHashStr := fmt.Sprintf("%x", guessBlock.Hash())
-	return guessHashStr[:difficulty] == zeroes
+// AvailableAmount is the confirmed balance of blockchainAddress minus what
+// it is already spending in the transaction pool.
+func (bc *BlockChain) AvailableAmount(blockchainAddress string) (utils.Amount, error) {
+	if bc.usesUTXO() {
+		return sumOutputs(bc.spendableOutputs(blockchainAddress))
+	}
+	available, err := bc.CalculateTotalAmount(blockchainAddress)
+	if err != nil {
+		return 0, err
+	}
+	for _, e := range bc.transactionPool.from(blockchainAddress) {
+		cost, err := e.tx.Cost()
+		if err != nil {
+			return 0, err
+		}
+		if available, err = utils.SubAmount(available, cost); err != nil {
+			return 0, err
+		}
+	}
+	return available, nil
 }
 
-func (bc *BlockChain) ProofOfWork() int {
-	transactions := bc.CopyTransactionPool()
-	previousHash := bc.LastBlock().Hash()
-	nonce := 0
-	for !bc.ValidProof(nonce, previousHash, transactions, MINING_DIFICULTY) {
-		nonce += 1
-	}
-	return nonce
+// ConfirmedNonce is the number of transactions blockchainAddress has sent
+// in the chain, which is also the nonce its next transaction must carry.
+func (bc *BlockChain) ConfirmedNonce(blockchainAddress string) uint64 {
+	return bc.state.nonce(blockchainAddress)
 }
 
-func (bc *BlockChain) Mining() bool {
+// NextNonce is the nonce expected from blockchainAddress once its pending
+// pool transactions are taken into account.
+func (bc *BlockChain) NextNonce(blockchainAddress string) uint64 {
 	bc.mux.Lock()
 	defer bc.mux.Unlock()
+	return bc.nextNonce(blockchainAddress)
+}
 
-	if len(bc.transactionPool) == 0 {
-		return false
-	}
+func (bc *BlockChain) nextNonce(blockchainAddress string) uint64 {
+	return bc.ConfirmedNonce(blockchainAddress) + uint64(len(bc.transactionPool.from(blockchainAddress)))
+}
 
-	bc.AddTransaction(MINING_SENDER, bc.blockhainAddress, MINING_REWARD, nil, nil)
-	nonce := bc.ProofOfWork()
-	previousHash := bc.LastBlock().Hash()
-	bc.CreateBlock(nonce, previousHash)
-	log.Println("action=mining, status=success")
+func (bc *BlockChain) VerifyTransactionSignature(
+	senderPublicKey *ecdsa.PublicKey, s *utils.Signature, t *Transaction) bool {
+	h := t.SigningHash()
+	return ecdsa.Verify(senderPublicKey, h[:], s.R, s.S)
+}
 
-	for _, n := range bc.neighbors{
-		endpoint := fmt.Sprintf("http://%s/consensus", n)
-		client := &http.Client{}
-		req, _ := http.NewRequest("PUT", endpoint, nil)
-		resp, _ := client.Do(req)
-		log.Printf("%v", resp)
+// CopyTransactionPool copies the pool in the order a block would take it:
+// highest fee rate first, each sender's transactions in nonce order.
+func (bc *BlockChain) CopyTransactionPool() []*Transaction {
+	transactions := make([]*Transaction, 0)
+	for _, t := range bc.transactionPool.ordered() {
+		c := *t
+		transactions = append(transactions, &c)
 	}
-	return true
+	return transactions
 }
 
-func (bc *BlockChain) StartMining() {
-	bc.Mining()
-	_ = time.AfterFunc(time.Second * MINING_TIMER_SEC, bc.StartMining)
+// CalculateTotalAmount is the confirmed balance of blockchainAddress, read
+// from the state index.
+func (bc *BlockChain) CalculateTotalAmount(blockchainAddress string) (utils.Amount, error) {
+	return bc.state.balance(blockchainAddress), nil
 }
 
-func (bc *BlockChain) CalculateTotalAmount(blockchainAddress string) float32 {
-	var totalAmount float32 = 0.0
-	for _, b := range bc.chain {
-		for _, t := range b.transactions {
-			value := t.value
-			if blockchainAddress == t.recipientBlockchainAddress {
-				totalAmount += value
-			}
-			if blockchainAddress == t.senderBlockchainAddress {
-				totalAmount -= value
+// MerkleProof finds the block containing the transaction with id txID and
+// returns the path linking it to that block's merkle root.
+func (bc *BlockChain) MerkleProof(txID [32]byte) (*MerkleProofResponse, error) {
+	for _, b := range bc.Chain() {
+		ids := transactionIDs(b.transactions)
+		for i, id := range ids {
+			if id != txID {
+				continue
 			}
+			return &MerkleProofResponse{
+				TransactionID: txID,
+				BlockHash:     b.Hash(),
+				Height:        b.header.height,
+				MerkleRoot:    b.header.merkleRoot,
+				Index:         i,
+				Proof:         MerkleProof(ids, i),
+			}, nil
 		}
 	}
-	return totalAmount
+	return nil, ErrTransactionNotFound
+}
+
+func (bc *BlockChain) ValidChain(chain []*Block) error {
+	_, _, err := bc.replayChain(chain)
+	return err
 }
 
-func (bc *BlockChain) ValidChain(chain []*Block) bool {
-	preBlock := chain[0]
-	currentIndex := 1
-	for currentIndex < len(chain){
-		b := chain[currentIndex]
-		if b.previousHash != preBlock.Hash() {
-			return false
+// replayChain validates chain from genesis and returns the state it ends
+// in along with the undo record of every block.
+func (bc *BlockChain) replayChain(chain []*Block) (*chainState, []*stateUndo, error) {
+	if len(chain) == 0 {
+		return nil, nil, errors.New("empty chain")
+	}
+	headers := make([]*BlockHeader, 0, len(chain))
+	state := newChainState(bc.params)
+	undos := make([]*stateUndo, 0, len(chain))
+	for i, b := range chain {
+		if err := bc.validHeader(headers, b.Header()); err != nil {
+			return nil, nil, err
+		}
+		if err := validBlockStructure(b, i, bc.params); err != nil {
+			return nil, nil, err
 		}
-		if !bc.ValidProof(b.Nonce(), b.PreviuosHash(), b.Transaction(), MINING_DIFICULTY){
-			return false
+		undo, err := state.connectBlock(i, b)
+		if err != nil {
+			return nil, nil, err
 		}
-		preBlock = b
-		currentIndex += 1
+		undos = append(undos, undo)
+		headers = append(headers, b.Header())
 	}
-	return true
+	return state, undos, nil
 }
 
-
-
-func (bc *BlockChain) ResolveConflicts() bool {
-	var longestChain []*Block = nil
-	maxLenght := len(bc.chain)
-
-	for _, n := range bc.neighbors {
-		endpoint := fmt.Sprintf("http://%s/chain", n)
-		resp, _ := http.Get(endpoint)
-		if resp.StatusCode == 200 {
-			var bcResp BlockChain
-			decoder := json.NewDecoder(resp.Body)
-			_ = decoder.Decode(&bcResp)
-			chain := bcResp.chain
-			for _, v := range chain{
-				v.Print()
-			}
-			if len(chain) > maxLenght && bc.ValidChain(chain) {
-				maxLenght = len(chain)
-				longestChain = chain
-			}
+// validHeader checks h as the successor of the headers in prev: its height
+// and previous hash must follow on, its bits must match the retarget
+// schedule and its hash must meet them.
+func (bc *BlockChain) validHeader(prev []*BlockHeader, h *BlockHeader) error {
+	height := len(prev)
+	if h.version != BLOCK_VERSION {
+		return fmt.Errorf("block %d: unsupported version %d", height, h.version)
+	}
+	if h.height != uint64(height) {
+		return fmt.Errorf("block %d: header claims height %d", height, h.height)
+	}
+	if height == 0 {
+		if h.Hash() != bc.genesis.Hash() {
+			return fmt.Errorf("block 0: %w", ErrGenesisMismatch)
 		}
+		return nil
 	}
-	if longestChain != nil {
-		bc.chain = longestChain
-		log.Printf("Resolve conflicts replaced")
-		return true
+	if h.previousHash != prev[height-1].Hash() {
+		return fmt.Errorf("block %d: previous hash mismatch", height)
 	}
-	log.Printf("Resolve conflicts not replaced")
-	return false
-}
-
-type Transaction struct {
-	senderBlockchainAddress    string
-	recipientBlockchainAddress string
-	value                      float32
-}
-
-func NewTransaction(sender string, recipient string, value float32) *Transaction {
-	return &Transaction{sender, recipient, value}
-}
-
-func (t *Transaction) Print() {
-	fmt.Printf("%s\n", strings.Repeat("-", 40))
-	fmt.Printf("sender_blockchain_address    %s\n", t.senderBlockchainAddress)
-	fmt.Printf("recipient_blockchain_address %s\n", t.recipientBlockchainAddress)
-	fmt.Printf("value                        %.1f\n", t.value)
-}
-
-func (t *Transaction) MarshalJSON() ([]byte, error) {
-	return json.Marshal(struct {
-		Sender    string  `json:"sender_blockchain_address"`
-		Recipient string  `json:"recipient_blockchain_address"`
-		Value     float32 `json:"value"`
-	}{
-		Sender:    t.senderBlockchainAddress,
-		Recipient: t.recipientBlockchainAddress,
-		Value:     t.value,
-	})
+	if mtp := bc.params.MedianTimePast(prev); h.timestamp <= mtp {
+		return fmt.Errorf("block %d: %w: %d <= %d", height, ErrTimestampTooOld, h.timestamp, mtp)
+	}
+	if limit := bc.clock().Add(time.Duration(bc.params.MaxFutureDriftSec) * time.Second).UnixNano(); h.timestamp > limit {
+		return fmt.Errorf("block %d: %w: %d > %d", height, ErrTimestampTooNew, h.timestamp, limit)
+	}
+	if expected := bc.params.NextBits(prev); h.bits != expected {
+		return fmt.Errorf("block %d: %w: got %08x, expected %08x", height, ErrInvalidBits, h.bits, expected)
+	}
+	if err := bc.params.checkBits(h.bits); err != nil {
+		return fmt.Errorf("block %d: %w", height, err)
+	}
+	if !bc.ValidProof(h) {
+		return fmt.Errorf("block %d: invalid proof of work", height)
+	}
+	return nil
 }
 
-func (t *Transaction) UnmarshalJSON(data []byte) error {
-	v := struct{
-		Sender    *string  `json:"sender_blockchain_address"`
-		Recipient *string  `json:"recipient_blockchain_address"`
-		Value     *float32 `json:"value"`
-	}{
-		Sender:    &t.senderBlockchainAddress,
-		Recipient: &t.recipientBlockchainAddress,
-		Value:     &t.value,
+// validBlockStructure checks that the header of b commits to its
+// transactions and that b stays within the size and transaction count
+// limits of params.
+func validBlockStructure(b *Block, height int, params *ChainParams) error {
+	if b.header.merkleRoot != MerkleRoot(transactionIDs(b.transactions)) {
+		return fmt.Errorf("block %d: merkle root mismatch", height)
 	}
-	if err := json.Unmarshal(data, &v); err != nil {
-		return err
+	if err := params.checkBlockLimits(b); err != nil {
+		return fmt.Errorf("block %d: %w", height, err)
 	}
 	return nil
 }
 
-
+// TransactionRequest carries either a recipient and value, on the account
+// ledger, or inputs and outputs, on the UTXO ledger.
 type TransactionRequest struct {
-	SenderBlockchainAddress    *string `json:"sender_blockchain_address"`
-	RecipientBlockchainAddress *string `json:"recipient_blockchain_address"`
-	SenderPublicKey            *string `json:"sender_public_key"`
-	Value                      *float32 `json:"value"`
-	Signature                  *string `json:"signature"`
+	SenderBlockchainAddress    *string          `json:"sender_blockchain_address"`
+	RecipientBlockchainAddress *string          `json:"recipient_blockchain_address,omitempty"`
+	SenderPublicKey            *string          `json:"sender_public_key"`
+	Value                      *string          `json:"value,omitempty"`
+	Inputs                     []utils.OutPoint `json:"inputs,omitempty"`
+	Outputs                    []OutputRequest  `json:"outputs,omitempty"`
+	Fee                        *string          `json:"fee"`
+	Nonce                      *uint64          `json:"nonce"`
+	Signature                  *string          `json:"signature"`
+}
+
+// OutputRequest is an output with its value as a decimal string.
+type OutputRequest struct {
+	Address string `json:"address"`
+	Value   string `json:"value"`
+}
+
+// ParseOutputs converts the requested outputs to base units.
+func (bc *BlockChain) ParseOutputs(requests []OutputRequest) ([]utils.TxOutput, error) {
+	outputs := make([]utils.TxOutput, len(requests))
+	for i, o := range requests {
+		value, err := bc.ParseAmount(o.Value)
+		if err != nil {
+			return nil, err
+		}
+		outputs[i] = utils.TxOutput{Address: o.Address, Value: value}
+	}
+	return outputs, nil
 }
 
 func (tx *TransactionRequest) GetTransactionRequest() {
 	fmt.Printf("%s\n", strings.Repeat("-", 40))
 	fmt.Printf("sender_blockchain_address    %s\n", *tx.SenderBlockchainAddress)
-	fmt.Printf("recipient_blockchain_address %s\n", *tx.RecipientBlockchainAddress)
+	if tx.RecipientBlockchainAddress != nil {
+		fmt.Printf("recipient_blockchain_address %s\n", *tx.RecipientBlockchainAddress)
+	}
 	fmt.Printf("sender_public_key %s\n", *tx.SenderPublicKey)
-	fmt.Printf("value                        %.1f\n", *tx.Value)
+	if tx.Value != nil {
+		fmt.Printf("value                        %s\n", *tx.Value)
+	}
+	for _, in := range tx.Inputs {
+		fmt.Printf("input                        %s\n", in)
+	}
+	for _, out := range tx.Outputs {
+		fmt.Printf("output                       %s %s\n", out.Address, out.Value)
+	}
+	fmt.Printf("fee                          %s\n", *tx.Fee)
+	fmt.Printf("nonce                        %d\n", *tx.Nonce)
 	fmt.Printf("signature                        %s\n", *tx.Signature)
 }
 
 func (tr *TransactionRequest) Validate() bool {
 	if tr.SenderBlockchainAddress == nil ||
-	tr.RecipientBlockchainAddress == nil ||
-	tr.SenderPublicKey == nil ||
-	tr.Value == nil ||
-	tr.Signature == nil {
+		tr.SenderPublicKey == nil ||
+		(tr.Outputs == nil && (tr.RecipientBlockchainAddress == nil || tr.Value == nil)) ||
+		tr.Fee == nil ||
+		tr.Nonce == nil ||
+		tr.Signature == nil {
 		return false
 	}
-	return true
+	// The key and signature are decoded by slicing, so their shape is
+	// checked before anything reads them.
+	return isHexPair(*tr.SenderPublicKey) && isHexPair(*tr.Signature)
 }
 
+// AmountResponse carries an amount as an exact decimal string.
 type AmountResponse struct {
-	Amount float32 `json:"amount"`
+	Amount string `json:"amount"`
 }
 
 func (ar *AmountResponse) MarshalJSON() ([]byte, error) {
 	return json.Marshal(struct{
-		Amount float32 `json:"amount"`
+		Amount string `json:"amount"`
 	}{
 		Amount: ar.Amount,
 	})
-}
\ No newline at end of file
+}
+
+type NonceResponse struct {
+	Nonce uint64 `json:"nonce"`
+}
+
+func (nr *NonceResponse) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct{
+		Nonce uint64 `json:"nonce"`
+	}{
+		Nonce: nr.Nonce,
+	})
+}
@@ -0,0 +1,51 @@
+package block
+
+import (
+	"errors"
+	"goblockchain/wallet"
+	"testing"
+)
+
+func TestTransactionRejections(t *testing.T) {
+	a, b := wallet.NewWallet(), wallet.NewWallet()
+	bc := newTestChain(t, fundedParams(a))
+	if err := bc.ReceiveTransaction(signedTransfer(a, b, 1000, 100000, 0), 1, ""); err != nil {
+		t.Fatal(err)
+	}
+	mineBlocks(t, bc, 1)
+
+	balance, err := bc.CalculateTotalAmount(a.BlockChainAddress())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	valid := signedTransfer(a, b, 1000, 100000, 1)
+	tampered := NewTransaction(a.BlockChainAddress(), b.BlockChainAddress(), 2000, 100000, 1,
+		a.PublicKey(), valid.signature)
+	// Signed correctly, but with the key of b for a transfer out of a.
+	foreign := wallet.NewTransaction(b.PrivateKey(), b.PublicKey(), a.BlockChainAddress(), b.BlockChainAddress(), 1000, 100000, 1)
+	impostor := NewTransaction(a.BlockChainAddress(), b.BlockChainAddress(), 1000, 100000, 1,
+		b.PublicKey(), foreign.GenerateSignature())
+
+	tests := []struct {
+		name string
+		tx   *Transaction
+		err  error
+	}{
+		{"more than the balance", signedTransfer(a, b, balance, 100000, 1), ErrInsufficientBalance},
+		{"reused nonce", signedTransfer(a, b, 2000, 100000, 0), ErrNonceTooLow},
+		{"altered after signing", tampered, ErrInvalidSignature},
+		{"key of another address", impostor, ErrSenderMismatch},
+	}
+	for _, tt := range tests {
+		if err := bc.ReceiveTransaction(tt.tx, 1, ""); !errors.Is(err, tt.err) {
+			t.Errorf("%s: %v, want %v", tt.name, err, tt.err)
+		}
+	}
+	if n := len(bc.TransactionPool()); n != 0 {
+		t.Fatalf("pool holds %d rejected transactions", n)
+	}
+	if err := bc.ReceiveTransaction(valid, 1, ""); err != nil {
+		t.Fatalf("valid transaction after the rejections: %v", err)
+	}
+}
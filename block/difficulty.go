@@ -0,0 +1,105 @@
+package block
+
+import (
+	"errors"
+	"math/big"
+	"sort"
+)
+
+var ErrInvalidBits = errors.New("invalid difficulty bits")
+
+// CompactToTarget expands the compact "bits" encoding of a target: the top
+// byte is the length of the target in bytes and the low three bytes are
+// its most significant digits. Negative or empty encodings give zero.
+func CompactToTarget(bits uint32) *big.Int {
+	mantissa := int64(bits & 0x007fffff)
+	exponent := uint(bits >> 24)
+	if bits&0x00800000 != 0 {
+		return new(big.Int)
+	}
+	target := big.NewInt(mantissa)
+	if exponent <= 3 {
+		return target.Rsh(target, 8*(3-exponent))
+	}
+	return target.Lsh(target, 8*(exponent-3))
+}
+
+// TargetToCompact is the inverse of CompactToTarget, rounding the target
+// down to three significant bytes.
+func TargetToCompact(target *big.Int) uint32 {
+	size := uint((target.BitLen() + 7) / 8)
+	var mantissa uint32
+	if size <= 3 {
+		mantissa = uint32(target.Uint64() << (8 * (3 - size)))
+	} else {
+		mantissa = uint32(new(big.Int).Rsh(target, 8*(size-3)).Uint64())
+	}
+	// The sign bit of the mantissa must stay clear.
+	if mantissa&0x00800000 != 0 {
+		mantissa >>= 8
+		size++
+	}
+	return mantissa | uint32(size)<<24
+}
+
+// HashMeetsTarget reports whether hash, read as a big-endian number, is at
+// or below the target encoded by bits.
+func HashMeetsTarget(hash [32]byte, bits uint32) bool {
+	target := CompactToTarget(bits)
+	if target.Sign() <= 0 {
+		return false
+	}
+	return new(big.Int).SetBytes(hash[:]).Cmp(target) <= 0
+}
+
+// NextBits returns the bits the block following headers has to carry. The
+// target only moves on multiples of the retarget window, scaled by how far
+// the window's actual timespan strayed from the intended one and clamped
+// to a factor of four either way.
+func (p *ChainParams) NextBits(headers []*BlockHeader) uint32 {
+	height := len(headers)
+	if height == 0 {
+		return p.GenesisBits
+	}
+	last := headers[height-1]
+	if height%p.RetargetWindow != 0 {
+		return last.bits
+	}
+	first := headers[height-p.RetargetWindow]
+	expected := int64(p.RetargetWindow-1) * p.BlockIntervalSec * 1e9
+	actual := last.timestamp - first.timestamp
+	if actual < expected/4 {
+		actual = expected / 4
+	}
+	if actual > expected*4 {
+		actual = expected * 4
+	}
+	target := CompactToTarget(last.bits)
+	target.Mul(target, big.NewInt(actual))
+	target.Div(target, big.NewInt(expected))
+	limit := CompactToTarget(p.PowLimitBits)
+	if target.Cmp(limit) > 0 {
+		target = limit
+	}
+	return TargetToCompact(target)
+}
+
+// MedianTimePast is the median timestamp of the last MedianTimeSpan
+// headers, or of all of them on a shorter chain. A block has to be dated
+// after it, which keeps timestamps moving forward without requiring every
+// block to be later than its parent.
+func (p *ChainParams) MedianTimePast(headers []*BlockHeader) int64 {
+	start := len(headers) - p.MedianTimeSpan
+	if start < 0 {
+		start = 0
+	}
+	timestamps := make([]int64, 0, len(headers)-start)
+	for _, h := range headers[start:] {
+		timestamps = append(timestamps, h.timestamp)
+	}
+	if len(timestamps) == 0 {
+		return 0
+	}
+	sort.Slice(timestamps, func(i, j int) bool { return timestamps[i] < timestamps[j] })
+	return timestamps[len(timestamps)/2]
+}
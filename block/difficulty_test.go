@@ -0,0 +1,133 @@
+package block
+
+import (
+	"errors"
+	"math/big"
+	"testing"
+	"time"
+)
+
+// stoppedClock always reads t.
+func stoppedClock(t time.Time) func() time.Time {
+	return func() time.Time { return t }
+}
+
+// nextHeader is a header on top of the tip of bc dated timestamp. It has no
+// valid proof of work, which validHeader checks only after the timestamp.
+func nextHeader(bc *BlockChain, timestamp int64) *BlockHeader {
+	tip := bc.Tip()
+	h := *bc.Chain()[tip.Height].Header()
+	h.height = tip.Height + 1
+	h.previousHash = tip.Hash
+	h.timestamp = timestamp
+	return &h
+}
+
+func TestMedianTimePastRule(t *testing.T) {
+	params := DefaultChainParams()
+	bc := newTestChain(t, params)
+	bc.SetClock(stoppedClock(time.Unix(0, params.GenesisTimestamp)))
+	mineBlocks(t, bc, 4)
+
+	prev := headersOf(bc.Chain())
+	for i := 1; i < len(prev); i++ {
+		if mtp := params.MedianTimePast(prev[:i]); prev[i].timestamp <= mtp {
+			t.Fatalf("block %d dated %d, not after the median time past %d", i, prev[i].timestamp, mtp)
+		}
+	}
+	mtp := params.MedianTimePast(prev)
+	if err := bc.validHeader(prev, nextHeader(bc, mtp)); !errors.Is(err, ErrTimestampTooOld) {
+		t.Fatalf("header dated at the median time past: %v, want %v", err, ErrTimestampTooOld)
+	}
+	if err := bc.validHeader(prev, nextHeader(bc, mtp+1)); errors.Is(err, ErrTimestampTooOld) {
+		t.Fatalf("header dated after the median time past: %v", err)
+	}
+}
+
+func TestFutureDriftRule(t *testing.T) {
+	params := DefaultChainParams()
+	bc := newTestChain(t, params)
+	now := time.Unix(0, params.GenesisTimestamp).Add(time.Hour)
+	bc.SetClock(stoppedClock(now))
+
+	prev := headersOf(bc.Chain())
+	limit := now.Add(time.Duration(params.MaxFutureDriftSec) * time.Second).UnixNano()
+	if err := bc.validHeader(prev, nextHeader(bc, limit)); errors.Is(err, ErrTimestampTooNew) {
+		t.Fatalf("header dated at the drift limit: %v", err)
+	}
+	if err := bc.validHeader(prev, nextHeader(bc, limit+1)); !errors.Is(err, ErrTimestampTooNew) {
+		t.Fatalf("header dated past the drift limit: %v, want %v", err, ErrTimestampTooNew)
+	}
+	bc.SetClock(stoppedClock(now.Add(time.Second)))
+	if err := bc.validHeader(prev, nextHeader(bc, limit+1)); errors.Is(err, ErrTimestampTooNew) {
+		t.Fatalf("header within the drift limit once the clock moved: %v", err)
+	}
+}
+
+func TestCompactRoundTrip(t *testing.T) {
+	for _, bits := range []uint32{0x01120000, 0x02008000, 0x02123400, 0x03123456, 0x05012345, 0x1d00ffff, MINING_DIFICULTY_BITS, POW_LIMIT_BITS} {
+		if got := TargetToCompact(CompactToTarget(bits)); got != bits {
+			t.Errorf("round trip of %08x gave %08x", bits, got)
+		}
+	}
+	for _, s := range []string{"80", "ff", "123456789", "ffffffffffffffffffff", "7fffff0000000000000000000000000000000000000000000000000000000000"} {
+		target, _ := new(big.Int).SetString(s, 16)
+		bits := TargetToCompact(target)
+		back := CompactToTarget(bits)
+		if back.Cmp(target) > 0 {
+			t.Errorf("%s encoded as %08x expands to the larger %x", s, bits, back)
+		}
+		// Only the bytes below the three of the mantissa may be dropped.
+		dropped := 0
+		if size := int(bits >> 24); size > 3 {
+			dropped = 8 * (size - 3)
+		}
+		if lost := new(big.Int).Sub(target, back); lost.BitLen() > dropped {
+			t.Errorf("%s encoded as %08x expands to %x, losing more than its low %d bits", s, bits, back, dropped)
+		}
+	}
+	if CompactToTarget(0x04923456).Sign() != 0 || HashMeetsTarget([32]byte{}, 0x04923456) {
+		t.Error("negative encoding gave a target")
+	}
+}
+
+// retargetHeaders is one retarget window of headers at bits spanning
+// timespan nanoseconds from first to last.
+func retargetHeaders(p *ChainParams, bits uint32, timespan int64) []*BlockHeader {
+	headers := make([]*BlockHeader, p.RetargetWindow)
+	for i := range headers {
+		headers[i] = &BlockHeader{bits: bits, timestamp: timespan * int64(i) / int64(p.RetargetWindow-1)}
+	}
+	return headers
+}
+
+func TestNextBitsClampsRetarget(t *testing.T) {
+	p := DefaultChainParams()
+	const bits = 0x1d00ffff
+	target := CompactToTarget(bits)
+	intended := int64(p.RetargetWindow-1) * p.BlockIntervalSec * 1e9
+	scaled := func(num, den int64) uint32 {
+		s := new(big.Int).Mul(target, big.NewInt(num))
+		return TargetToCompact(s.Div(s, big.NewInt(den)))
+	}
+	tests := []struct {
+		name     string
+		bits     uint32
+		timespan int64
+		want     uint32
+	}{
+		{"on time", bits, intended, bits},
+		{"twice as slow", bits, 2 * intended, scaled(2, 1)},
+		{"far too fast", bits, 1, scaled(1, 4)},
+		{"far too slow", bits, 100 * intended, scaled(4, 1)},
+		{"slow at the pow limit", p.PowLimitBits, 100 * intended, p.PowLimitBits},
+	}
+	for _, tt := range tests {
+		if got := p.NextBits(retargetHeaders(p, tt.bits, tt.timespan)); got != tt.want {
+			t.Errorf("%s: bits %08x, want %08x", tt.name, got, tt.want)
+		}
+	}
+	if got := p.NextBits(retargetHeaders(p, bits, 1)[:p.RetargetWindow-1]); got != bits {
+		t.Errorf("between retargets: bits %08x, want %08x", got, bits)
+	}
+}
@@ -0,0 +1,88 @@
+package block
+
+import (
+	"encoding/json"
+	"goblockchain/utils"
+)
+
+// Subsidy is the new coin a coinbase at height may mint: the initial
+// reward halved once per elapsed halving interval, and never more than
+// what is left under the maximum supply once issued has been paid out.
+func (p *ChainParams) Subsidy(height int, issued utils.Amount) utils.Amount {
+	reward := p.InitialReward
+	if p.HalvingInterval > 0 {
+		halvings := height / p.HalvingInterval
+		if halvings >= 64 {
+			return 0
+		}
+		reward >>= uint(halvings)
+	}
+	if issued >= p.MaxSupply {
+		return 0
+	}
+	if left := p.MaxSupply - issued; reward > left {
+		reward = left
+	}
+	return reward
+}
+
+// NextHalving is the first height above height whose subsidy is halved
+// again, or 0 when the subsidy never changes.
+func (p *ChainParams) NextHalving(height int) int {
+	if p.HalvingInterval == 0 {
+		return 0
+	}
+	return (height/p.HalvingInterval + 1) * p.HalvingInterval
+}
+
+// IssuedSupply is the subsidy minted by the current chain.
+func (bc *BlockChain) IssuedSupply() utils.Amount {
+	issued, _ := bc.state.supply()
+	return issued
+}
+
+// Supply reports the coin in existence and where the emission schedule
+// stands for the next block.
+func (bc *BlockChain) Supply() (*SupplyResponse, error) {
+	bc.mux.Lock()
+	defer bc.mux.Unlock()
+	issued, circulating := bc.state.supply()
+	height := len(bc.chain)
+	return &SupplyResponse{
+		Height:            height - 1,
+		Issued:            bc.FormatAmount(issued),
+		Circulating:       bc.FormatAmount(circulating),
+		MaxSupply:         bc.FormatAmount(bc.params.MaxSupply),
+		BlockSubsidy:      bc.FormatAmount(bc.params.Subsidy(height, issued)),
+		NextHalvingHeight: bc.params.NextHalving(height),
+	}, nil
+}
+
+// SupplyResponse carries amounts as exact decimal strings like
+// AmountResponse. BlockSubsidy is what the next block may mint.
+type SupplyResponse struct {
+	Height            int
+	Issued            string
+	Circulating       string
+	MaxSupply         string
+	BlockSubsidy      string
+	NextHalvingHeight int
+}
+
+func (sr *SupplyResponse) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Height            int    `json:"height"`
+		Issued            string `json:"issued"`
+		Circulating       string `json:"circulating"`
+		MaxSupply         string `json:"max_supply"`
+		BlockSubsidy      string `json:"block_subsidy"`
+		NextHalvingHeight int    `json:"next_halving_height"`
+	}{
+		Height:            sr.Height,
+		Issued:            sr.Issued,
+		Circulating:       sr.Circulating,
+		MaxSupply:         sr.MaxSupply,
+		BlockSubsidy:      sr.BlockSubsidy,
+		NextHalvingHeight: sr.NextHalvingHeight,
+	})
+}
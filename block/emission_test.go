@@ -0,0 +1,94 @@
+package block
+
+import (
+	"goblockchain/utils"
+	"testing"
+)
+
+func TestSubsidyHalvingBoundaries(t *testing.T) {
+	p := DefaultChainParams()
+	p.InitialReward = 1000
+	p.HalvingInterval = 10
+	tests := []struct {
+		height int
+		want   utils.Amount
+		next   int
+	}{
+		{0, 1000, 10},
+		{9, 1000, 10},
+		{10, 500, 20},
+		{19, 500, 20},
+		{20, 250, 30},
+		{90, 1, 100},
+		{100, 0, 110},
+		{630, 0, 640},
+		{640, 0, 650},
+	}
+	for _, tt := range tests {
+		if got := p.Subsidy(tt.height, 0); got != tt.want {
+			t.Errorf("subsidy at %d: %d, want %d", tt.height, got, tt.want)
+		}
+		if got := p.NextHalving(tt.height); got != tt.next {
+			t.Errorf("next halving after %d: %d, want %d", tt.height, got, tt.next)
+		}
+	}
+
+	p.HalvingInterval = 0
+	if got := p.Subsidy(1000000, 0); got != 1000 {
+		t.Errorf("subsidy without halvings: %d, want 1000", got)
+	}
+	if got := p.NextHalving(1000000); got != 0 {
+		t.Errorf("next halving without halvings: %d, want 0", got)
+	}
+}
+
+func TestSubsidyStopsAtMaxSupply(t *testing.T) {
+	p := DefaultChainParams()
+	p.InitialReward = 1000
+	p.MaxSupply = 5000
+	tests := []struct {
+		issued utils.Amount
+		want   utils.Amount
+	}{
+		{0, 1000},
+		{4000, 1000},
+		{4001, 999},
+		{4999, 1},
+		{5000, 0},
+		{6000, 0},
+	}
+	for _, tt := range tests {
+		if got := p.Subsidy(1, tt.issued); got != tt.want {
+			t.Errorf("subsidy with %d issued: %d, want %d", tt.issued, got, tt.want)
+		}
+	}
+}
+
+func TestMiningStopsMintingAtMaxSupply(t *testing.T) {
+	params := DefaultChainParams()
+	reward := params.InitialReward
+	params.HalvingInterval = 2
+	params.MaxSupply = reward + reward/2 + reward/4 + 1
+	bc := newTestChain(t, params)
+	blocks := mineBlocks(t, bc, 4)
+
+	// Block 1 mints the full reward, block 2 the halved one, and block 3
+	// only what is left under the cap.
+	want := []utils.Amount{reward, reward / 2, reward/4 + 1, 0}
+	for i, b := range blocks {
+		if got := b.transactions[0].value; got != want[i] {
+			t.Errorf("block %d mints %d, want %d", i+1, got, want[i])
+		}
+	}
+	supply, err := bc.Supply()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if supply.Issued != bc.FormatAmount(params.MaxSupply) || supply.BlockSubsidy != bc.FormatAmount(0) {
+		t.Fatalf("issued %s with subsidy %s, want the maximum supply %s and nothing more",
+			supply.Issued, supply.BlockSubsidy, bc.FormatAmount(params.MaxSupply))
+	}
+	if supply.NextHalvingHeight != 6 {
+		t.Fatalf("next halving at %d, want 6", supply.NextHalvingHeight)
+	}
+}
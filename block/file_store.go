@@ -0,0 +1,286 @@
+package block
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+const (
+	FILE_STORE_LOG   = "blocks.log"
+	FILE_STORE_INDEX = "blocks.idx"
+	// FILE_STORE_INDEX_ENTRY is the size of an index entry: the log offset
+	// of a height followed by the hash of its block.
+	FILE_STORE_INDEX_ENTRY = 8 + 32
+)
+
+var errInvalidIndex = errors.New("file store: index does not match the log")
+
+// FileStore keeps blocks in an append-only log of length-prefixed JSON
+// records. The index file holds the log offset and block hash of every
+// height, so opening the store only reads the log past the last indexed
+// record; the index is rebuilt from the log when the two disagree.
+type FileStore struct {
+	dir     string
+	log     *os.File
+	idx     *os.File
+	offsets []int64
+	hashes  map[[32]byte]int
+	size    int64
+	mux     sync.RWMutex
+}
+
+func OpenFileStore(dir string) (*FileStore, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	logFile, err := os.OpenFile(filepath.Join(dir, FILE_STORE_LOG), os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	idxFile, err := os.OpenFile(filepath.Join(dir, FILE_STORE_INDEX), os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		logFile.Close()
+		return nil, err
+	}
+	s := &FileStore{
+		dir:    dir,
+		log:    logFile,
+		idx:    idxFile,
+		hashes: make(map[[32]byte]int),
+	}
+	if err := s.load(); err != nil {
+		s.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *FileStore) load() error {
+	offset, err := s.loadIndex()
+	if err != nil {
+		s.offsets = nil
+		s.hashes = make(map[[32]byte]int)
+		offset = 0
+	}
+	// Records behind the index were written by an Append that crashed
+	// before the index entry.
+	for {
+		b, n, err := s.readAt(offset)
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("file store: record %d: %w", len(s.offsets), err)
+		}
+		s.hashes[b.Hash()] = len(s.offsets)
+		s.offsets = append(s.offsets, offset)
+		offset += n
+	}
+	// Drop a torn write left behind by a crash.
+	if err := s.log.Truncate(offset); err != nil {
+		return err
+	}
+	s.size = offset
+	return s.syncIndex()
+}
+
+// loadIndex takes the offsets and hashes from the index file and returns
+// the log offset after the last indexed record. Of the log, only that
+// record is read, to check that it is where the index says.
+func (s *FileStore) loadIndex() (int64, error) {
+	data, err := io.ReadAll(io.NewSectionReader(s.idx, 0, 1<<62))
+	if err != nil {
+		return 0, err
+	}
+	if len(data)%FILE_STORE_INDEX_ENTRY != 0 {
+		return 0, errInvalidIndex
+	}
+	n := len(data) / FILE_STORE_INDEX_ENTRY
+	if n == 0 {
+		return 0, nil
+	}
+	offsets := make([]int64, n)
+	hashes := make(map[[32]byte]int, n)
+	var hash [32]byte
+	for i := range offsets {
+		entry := data[i*FILE_STORE_INDEX_ENTRY:]
+		offsets[i] = int64(binary.BigEndian.Uint64(entry))
+		if (i == 0 && offsets[i] != 0) || (i > 0 && offsets[i] <= offsets[i-1]) {
+			return 0, errInvalidIndex
+		}
+		copy(hash[:], entry[8:FILE_STORE_INDEX_ENTRY])
+		hashes[hash] = i
+	}
+	b, size, err := s.readAt(offsets[n-1])
+	if err != nil || b.Hash() != hash {
+		return 0, errInvalidIndex
+	}
+	s.offsets, s.hashes = offsets, hashes
+	return offsets[n-1] + size, nil
+}
+
+func indexEntry(offset int64, hash [32]byte) []byte {
+	entry := make([]byte, FILE_STORE_INDEX_ENTRY)
+	binary.BigEndian.PutUint64(entry, uint64(offset))
+	copy(entry[8:], hash[:])
+	return entry
+}
+
+// syncIndex rewrites the index file if it does not match the offsets
+// recovered from the log.
+func (s *FileStore) syncIndex() error {
+	hashes := make([][32]byte, len(s.offsets))
+	for hash, height := range s.hashes {
+		hashes[height] = hash
+	}
+	want := make([]byte, 0, FILE_STORE_INDEX_ENTRY*len(s.offsets))
+	for i, o := range s.offsets {
+		want = append(want, indexEntry(o, hashes[i])...)
+	}
+	have, err := io.ReadAll(io.NewSectionReader(s.idx, 0, 1<<62))
+	if err != nil {
+		return err
+	}
+	if bytes.Equal(have, want) {
+		return nil
+	}
+	if err := s.idx.Truncate(0); err != nil {
+		return err
+	}
+	_, err = s.idx.WriteAt(want, 0)
+	return err
+}
+
+func (s *FileStore) readAt(offset int64) (*Block, int64, error) {
+	var prefix [4]byte
+	if _, err := s.log.ReadAt(prefix[:], offset); err != nil {
+		return nil, 0, err
+	}
+	length := binary.BigEndian.Uint32(prefix[:])
+	data := make([]byte, length)
+	if _, err := s.log.ReadAt(data, offset+4); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, 0, err
+	}
+	b := new(Block)
+	if err := json.Unmarshal(data, b); err != nil {
+		return nil, 0, err
+	}
+	return b, 4 + int64(length), nil
+}
+
+func (s *FileStore) Append(b *Block) error {
+	data, err := json.Marshal(b)
+	if err != nil {
+		return err
+	}
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	record := make([]byte, 4+len(data))
+	binary.BigEndian.PutUint32(record, uint32(len(data)))
+	copy(record[4:], data)
+	if _, err := s.log.WriteAt(record, s.size); err != nil {
+		return err
+	}
+	if err := s.log.Sync(); err != nil {
+		return err
+	}
+	hash := b.Hash()
+	if _, err := s.idx.WriteAt(indexEntry(s.size, hash), int64(FILE_STORE_INDEX_ENTRY*len(s.offsets))); err != nil {
+		return err
+	}
+	s.hashes[hash] = len(s.offsets)
+	s.offsets = append(s.offsets, s.size)
+	s.size += int64(len(record))
+	return nil
+}
+
+func (s *FileStore) BlockByHeight(height int) (*Block, error) {
+	s.mux.RLock()
+	defer s.mux.RUnlock()
+	if height < 0 || height >= len(s.offsets) {
+		return nil, ErrBlockNotFound
+	}
+	b, _, err := s.readAt(s.offsets[height])
+	return b, err
+}
+
+func (s *FileStore) BlockByHash(hash [32]byte) (*Block, error) {
+	s.mux.RLock()
+	height, ok := s.hashes[hash]
+	s.mux.RUnlock()
+	if !ok {
+		return nil, ErrBlockNotFound
+	}
+	return s.BlockByHeight(height)
+}
+
+func (s *FileStore) Iterate(fn func(height int, b *Block) bool) error {
+	for height := 0; height < s.Len(); height++ {
+		b, err := s.BlockByHeight(height)
+		if errors.Is(err, ErrBlockNotFound) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if !fn(height, b) {
+			return nil
+		}
+	}
+	return nil
+}
+
+func (s *FileStore) Tip() (*Block, error) {
+	return s.BlockByHeight(s.Len() - 1)
+}
+
+func (s *FileStore) Len() int {
+	s.mux.RLock()
+	defer s.mux.RUnlock()
+	return len(s.offsets)
+}
+
+func (s *FileStore) Truncate(height int) error {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	if height < 0 || height > len(s.offsets) {
+		return ErrBlockNotFound
+	}
+	if height == len(s.offsets) {
+		return nil
+	}
+	size := s.offsets[height]
+	if err := s.log.Truncate(size); err != nil {
+		return err
+	}
+	if err := s.idx.Truncate(int64(FILE_STORE_INDEX_ENTRY * height)); err != nil {
+		return err
+	}
+	for hash, h := range s.hashes {
+		if h >= height {
+			delete(s.hashes, hash)
+		}
+	}
+	s.offsets = s.offsets[:height]
+	s.size = size
+	return nil
+}
+
+func (s *FileStore) Close() error {
+	errLog := s.log.Close()
+	errIdx := s.idx.Close()
+	if errLog != nil {
+		return errLog
+	}
+	return errIdx
+}
@@ -0,0 +1,80 @@
+package block
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func reopenFileStore(t *testing.T, s *FileStore) *FileStore {
+	t.Helper()
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+	s, err := OpenFileStore(s.dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func checkStoredChain(t *testing.T, s *FileStore, chain []*Block) {
+	t.Helper()
+	if s.Len() != len(chain) {
+		t.Fatalf("store holds %d blocks, want %d", s.Len(), len(chain))
+	}
+	for i, b := range chain {
+		got, err := s.BlockByHash(b.Hash())
+		if err != nil {
+			t.Fatalf("block %d: %v", i, err)
+		}
+		if got.Hash() != b.Hash() {
+			t.Fatalf("block %d: hash %x, want %x", i, got.Hash(), b.Hash())
+		}
+	}
+}
+
+func TestFileStoreRecoversIndex(t *testing.T) {
+	dir := t.TempDir()
+	s, err := OpenFileStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bc, err := NewBlockchain("1BvBMSEYstWetqTFn5Au4m4GFg7xJaNVN2", 5001, s, DefaultChainParams())
+	if err != nil {
+		t.Fatal(err)
+	}
+	chain := append([]*Block{bc.Chain()[0]}, mineBlocks(t, bc, 3)...)
+	idx := filepath.Join(dir, FILE_STORE_INDEX)
+
+	s = reopenFileStore(t, s)
+	checkStoredChain(t, s, chain)
+
+	// An Append that crashed before writing its index entry.
+	if err := os.Truncate(idx, 2*FILE_STORE_INDEX_ENTRY); err != nil {
+		t.Fatal(err)
+	}
+	s = reopenFileStore(t, s)
+	checkStoredChain(t, s, chain)
+	if fi, _ := os.Stat(idx); fi.Size() != int64(len(chain)*FILE_STORE_INDEX_ENTRY) {
+		t.Fatalf("index holds %d bytes after recovery", fi.Size())
+	}
+
+	// An index that does not describe the log is rebuilt from it.
+	if err := os.WriteFile(idx, make([]byte, 3*FILE_STORE_INDEX_ENTRY), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	s = reopenFileStore(t, s)
+	checkStoredChain(t, s, chain)
+
+	// A torn record at the end of the log is dropped.
+	f, err := os.OpenFile(filepath.Join(dir, FILE_STORE_LOG), os.O_APPEND|os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Write([]byte{0, 0, 1, 0, '{'})
+	f.Close()
+	s = reopenFileStore(t, s)
+	checkStoredChain(t, s, chain)
+	s.Close()
+}
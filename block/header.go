@@ -0,0 +1,162 @@
+package block
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+)
+
+const (
+	BLOCK_VERSION       = 2
+	HEADER_SIZE         = 128
+	HEADER_NONCE_OFFSET = 120
+)
+
+// BlockHeader is the part of a block covered by proof of work. The
+// transactions are committed to through merkleRoot and the ledger they
+// leave behind through stateRoot.
+type BlockHeader struct {
+	version      uint32
+	height       uint64
+	previousHash [32]byte
+	merkleRoot   [32]byte
+	stateRoot    [32]byte
+	timestamp    int64
+	bits         uint32
+	nonce        uint64
+}
+
+func (h *BlockHeader) Version() uint32 {
+	return h.version
+}
+
+func (h *BlockHeader) Height() uint64 {
+	return h.height
+}
+
+func (h *BlockHeader) PreviousHash() [32]byte {
+	return h.previousHash
+}
+
+func (h *BlockHeader) MerkleRoot() [32]byte {
+	return h.merkleRoot
+}
+
+func (h *BlockHeader) StateRoot() [32]byte {
+	return h.stateRoot
+}
+
+func (h *BlockHeader) Timestamp() int64 {
+	return h.timestamp
+}
+
+func (h *BlockHeader) Bits() uint32 {
+	return h.bits
+}
+
+func (h *BlockHeader) Nonce() uint64 {
+	return h.nonce
+}
+
+// Bytes is the fixed-size serialization that is hashed: every field in
+// order, integers big-endian, with the nonce last so miners can vary it in
+// place.
+func (h *BlockHeader) Bytes() []byte {
+	buf := make([]byte, HEADER_SIZE)
+	binary.BigEndian.PutUint32(buf[0:], h.version)
+	binary.BigEndian.PutUint64(buf[4:], h.height)
+	copy(buf[12:44], h.previousHash[:])
+	copy(buf[44:76], h.merkleRoot[:])
+	copy(buf[76:108], h.stateRoot[:])
+	binary.BigEndian.PutUint64(buf[108:], uint64(h.timestamp))
+	binary.BigEndian.PutUint32(buf[116:], h.bits)
+	binary.BigEndian.PutUint64(buf[HEADER_NONCE_OFFSET:], h.nonce)
+	return buf
+}
+
+func (h *BlockHeader) Hash() [32]byte {
+	return sha256.Sum256(h.Bytes())
+}
+
+func (h *BlockHeader) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Version      uint32 `json:"version"`
+		Height       uint64 `json:"height"`
+		PreviousHash string `json:"previous_hash"`
+		MerkleRoot   string `json:"merkle_root"`
+		StateRoot    string `json:"state_root"`
+		Timestamp    int64  `json:"timestamp"`
+		Bits         uint32 `json:"bits"`
+		Nonce        uint64 `json:"nonce"`
+	}{
+		Version:      h.version,
+		Height:       h.height,
+		PreviousHash: fmt.Sprintf("%x", h.previousHash),
+		MerkleRoot:   fmt.Sprintf("%x", h.merkleRoot),
+		StateRoot:    fmt.Sprintf("%x", h.stateRoot),
+		Timestamp:    h.timestamp,
+		Bits:         h.bits,
+		Nonce:        h.nonce,
+	})
+}
+
+func (h *BlockHeader) UnmarshalJSON(data []byte) error {
+	var previousHash, merkleRoot, stateRoot string
+	v := &struct {
+		Version      *uint32 `json:"version"`
+		Height       *uint64 `json:"height"`
+		PreviousHash *string `json:"previous_hash"`
+		MerkleRoot   *string `json:"merkle_root"`
+		StateRoot    *string `json:"state_root"`
+		Timestamp    *int64  `json:"timestamp"`
+		Bits         *uint32 `json:"bits"`
+		Nonce        *uint64 `json:"nonce"`
+	}{
+		Version:      &h.version,
+		Height:       &h.height,
+		PreviousHash: &previousHash,
+		MerkleRoot:   &merkleRoot,
+		StateRoot:    &stateRoot,
+		Timestamp:    &h.timestamp,
+		Bits:         &h.bits,
+		Nonce:        &h.nonce,
+	}
+	if err := json.Unmarshal(data, v); err != nil {
+		return err
+	}
+	var err error
+	if h.previousHash, err = DecodeHash(previousHash); err != nil {
+		return fmt.Errorf("previous_hash: %w", err)
+	}
+	if h.merkleRoot, err = DecodeHash(merkleRoot); err != nil {
+		return fmt.Errorf("merkle_root: %w", err)
+	}
+	if h.stateRoot, err = DecodeHash(stateRoot); err != nil {
+		return fmt.Errorf("state_root: %w", err)
+	}
+	return nil
+}
+
+func headersOf(chain []*Block) []*BlockHeader {
+	headers := make([]*BlockHeader, len(chain))
+	for i, b := range chain {
+		headers[i] = b.Header()
+	}
+	return headers
+}
+
+// DecodeHash parses the hex form of a 32-byte hash.
+func DecodeHash(s string) ([32]byte, error) {
+	var hash [32]byte
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		return hash, err
+	}
+	if len(b) != len(hash) {
+		return hash, fmt.Errorf("invalid hash length %d", len(b))
+	}
+	copy(hash[:], b)
+	return hash, nil
+}
@@ -0,0 +1,84 @@
+package block
+
+import "sync"
+
+type MemoryStore struct {
+	blocks []*Block
+	index  map[[32]byte]int
+	mux    sync.RWMutex
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{index: make(map[[32]byte]int)}
+}
+
+func (s *MemoryStore) Append(b *Block) error {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	s.index[b.Hash()] = len(s.blocks)
+	s.blocks = append(s.blocks, b)
+	return nil
+}
+
+func (s *MemoryStore) BlockByHeight(height int) (*Block, error) {
+	s.mux.RLock()
+	defer s.mux.RUnlock()
+	if height < 0 || height >= len(s.blocks) {
+		return nil, ErrBlockNotFound
+	}
+	return s.blocks[height], nil
+}
+
+func (s *MemoryStore) BlockByHash(hash [32]byte) (*Block, error) {
+	s.mux.RLock()
+	defer s.mux.RUnlock()
+	height, ok := s.index[hash]
+	if !ok {
+		return nil, ErrBlockNotFound
+	}
+	return s.blocks[height], nil
+}
+
+func (s *MemoryStore) Iterate(fn func(height int, b *Block) bool) error {
+	s.mux.RLock()
+	blocks := s.blocks
+	s.mux.RUnlock()
+	for i, b := range blocks {
+		if !fn(i, b) {
+			break
+		}
+	}
+	return nil
+}
+
+func (s *MemoryStore) Tip() (*Block, error) {
+	s.mux.RLock()
+	defer s.mux.RUnlock()
+	if len(s.blocks) == 0 {
+		return nil, ErrBlockNotFound
+	}
+	return s.blocks[len(s.blocks)-1], nil
+}
+
+func (s *MemoryStore) Len() int {
+	s.mux.RLock()
+	defer s.mux.RUnlock()
+	return len(s.blocks)
+}
+
+func (s *MemoryStore) Truncate(height int) error {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	if height < 0 || height > len(s.blocks) {
+		return ErrBlockNotFound
+	}
+	for _, b := range s.blocks[height:] {
+		delete(s.index, b.Hash())
+	}
+	s.blocks = s.blocks[:height:height]
+	return nil
+}
+
+func (s *MemoryStore) Close() error {
+	return nil
+}
@@ -0,0 +1,265 @@
+package block
+
+import (
+	"container/heap"
+	"errors"
+	"goblockchain/utils"
+	"math"
+	"math/bits"
+)
+
+const (
+	MEMPOOL_MAX_SIZE           = 5000
+	DEFAULT_MIN_RELAY_FEE_RATE = 1
+)
+
+var (
+	ErrFeeTooLow   = errors.New("fee is below the minimum relay fee")
+	ErrMempoolFull = errors.New("mempool is full and the fee rate is too low to replace anything")
+)
+
+// poolEntry is a pending transaction with its encoded size, which the fee
+// rate is measured against, and its admission sequence for tie-breaks.
+type poolEntry struct {
+	tx   *Transaction
+	size uint64
+	seq  uint64
+}
+
+// feeRateLess reports whether a pays a lower fee per byte than b.
+func feeRateLess(a, b *poolEntry) bool {
+	aHi, aLo := bits.Mul64(uint64(a.tx.fee), b.size)
+	bHi, bLo := bits.Mul64(uint64(b.tx.fee), a.size)
+	return aHi < bHi || (aHi == bHi && aLo < bLo)
+}
+
+// mempool holds the transactions waiting for a block. Entries are kept in
+// admission order, which on the account ledger is also nonce order for
+// each sender; ordered hands them out by fee rate. On the UTXO ledger,
+// where pending transactions spend only confirmed outputs, no entry
+// depends on another and independent is set.
+type mempool struct {
+	entries     []*poolEntry
+	ids         map[[32]byte]bool
+	spent       map[utils.OutPoint]bool
+	senders     map[string][]*poolEntry
+	seq         uint64
+	maxSize     int
+	independent bool
+}
+
+func newMempool(maxSize int, independent bool) *mempool {
+	return &mempool{
+		ids:         make(map[[32]byte]bool),
+		spent:       make(map[utils.OutPoint]bool),
+		senders:     make(map[string][]*poolEntry),
+		maxSize:     maxSize,
+		independent: independent,
+	}
+}
+
+func newPoolEntry(t *Transaction) *poolEntry {
+	return &poolEntry{tx: t, size: t.Size()}
+}
+
+func (p *mempool) Len() int {
+	return len(p.entries)
+}
+
+func (p *mempool) Has(id [32]byte) bool {
+	return p.ids[id]
+}
+
+// from returns the pending transactions of sender in admission order.
+func (p *mempool) from(sender string) []*poolEntry {
+	return p.senders[sender]
+}
+
+// spends reports whether a pending transaction already spends o.
+func (p *mempool) spends(o utils.OutPoint) bool {
+	return p.spent[o]
+}
+
+func (p *mempool) full() bool {
+	return len(p.entries) >= p.maxSize
+}
+
+func (p *mempool) add(e *poolEntry) {
+	p.seq++
+	e.seq = p.seq
+	p.entries = append(p.entries, e)
+	p.ids[e.tx.ID()] = true
+	for _, o := range e.tx.inputs {
+		p.spent[o] = true
+	}
+	p.senders[e.tx.senderBlockchainAddress] = append(p.senders[e.tx.senderBlockchainAddress], e)
+}
+
+// remove drops every entry whose ID is in ids.
+func (p *mempool) remove(ids map[[32]byte]bool) {
+	entries := make([]*poolEntry, 0, len(p.entries))
+	senders := make(map[string]bool)
+	for _, e := range p.entries {
+		if ids[e.tx.ID()] {
+			delete(p.ids, e.tx.ID())
+			for _, o := range e.tx.inputs {
+				delete(p.spent, o)
+			}
+			senders[e.tx.senderBlockchainAddress] = true
+			continue
+		}
+		entries = append(entries, e)
+	}
+	p.entries = entries
+	for sender := range senders {
+		kept := make([]*poolEntry, 0, len(p.senders[sender]))
+		for _, e := range p.senders[sender] {
+			if !ids[e.tx.ID()] {
+				kept = append(kept, e)
+			}
+		}
+		if len(kept) == 0 {
+			delete(p.senders, sender)
+		} else {
+			p.senders[sender] = kept
+		}
+	}
+}
+
+func (p *mempool) clear() {
+	p.entries = p.entries[:0]
+	p.ids = make(map[[32]byte]bool)
+	p.spent = make(map[utils.OutPoint]bool)
+	p.senders = make(map[string][]*poolEntry)
+}
+
+// transactions returns the pending transactions in admission order.
+func (p *mempool) transactions() []*Transaction {
+	transactions := make([]*Transaction, len(p.entries))
+	for i, e := range p.entries {
+		transactions[i] = e.tx
+	}
+	return transactions
+}
+
+// evictionCandidate picks the entry with the lowest fee rate among those
+// no other pending transaction depends on, that is the last pending
+// transaction of each sender. The sender of the incoming transaction is
+// skipped so that evicting cannot open a gap in front of it. When entries
+// are independent, every one of them is a candidate.
+func (p *mempool) evictionCandidate(except string) *poolEntry {
+	candidates := p.entries
+	if !p.independent {
+		candidates = make([]*poolEntry, 0, len(p.senders))
+		for sender, entries := range p.senders {
+			if sender != except {
+				candidates = append(candidates, entries[len(entries)-1])
+			}
+		}
+	}
+	var victim *poolEntry
+	for _, e := range candidates {
+		if victim == nil || feeRateLess(e, victim) || (!feeRateLess(victim, e) && e.seq > victim.seq) {
+			victim = e
+		}
+	}
+	return victim
+}
+
+// ordered returns the pending transactions highest fee rate first while
+// keeping each sender's transactions in nonce order.
+func (p *mempool) ordered() []*Transaction {
+	return p.selectTransactions(math.MaxUint64, math.MaxInt)
+}
+
+// selectTransactions takes transactions in the order of ordered until
+// their total size or count would exceed the limits. A transaction that
+// does not fit is skipped together with the rest of its sender's queue,
+// which depends on it, and smaller transactions of other senders still
+// get a chance. Independent entries each form a queue of their own.
+func (p *mempool) selectTransactions(maxSize uint64, maxCount int) []*Transaction {
+	queues := make(map[string][]*poolEntry)
+	h := make(entryHeap, 0, len(p.senders))
+	if p.independent {
+		h = append(h, p.entries...)
+	} else {
+		for sender, entries := range p.senders {
+			queues[sender] = entries
+			h = append(h, entries[0])
+		}
+	}
+	heap.Init(&h)
+	transactions := make([]*Transaction, 0)
+	var size uint64
+	for h.Len() > 0 && len(transactions) < maxCount {
+		e := heap.Pop(&h).(*poolEntry)
+		if e.size > maxSize-size {
+			continue
+		}
+		transactions = append(transactions, e.tx)
+		size += e.size
+		sender := e.tx.senderBlockchainAddress
+		if len(queues[sender]) > 1 {
+			queues[sender] = queues[sender][1:]
+			heap.Push(&h, queues[sender][0])
+		}
+	}
+	return transactions
+}
+
+// entryHeap pops the highest fee rate first, the earliest admitted on ties.
+type entryHeap []*poolEntry
+
+func (h entryHeap) Len() int { return len(h) }
+func (h entryHeap) Less(i, j int) bool {
+	if feeRateLess(h[j], h[i]) {
+		return true
+	}
+	if feeRateLess(h[i], h[j]) {
+		return false
+	}
+	return h[i].seq < h[j].seq
+}
+func (h entryHeap) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *entryHeap) Push(x interface{}) { *h = append(*h, x.(*poolEntry)) }
+func (h *entryHeap) Pop() interface{} {
+	old := *h
+	e := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return e
+}
+
+// minFee is the smallest fee a transaction of size bytes must pay at rate
+// base units per byte.
+func minFee(rate utils.Amount, size uint64) (utils.Amount, error) {
+	hi, lo := bits.Mul64(uint64(rate), size)
+	if hi != 0 {
+		return 0, utils.ErrAmountOverflow
+	}
+	return utils.Amount(lo), nil
+}
+
+// SetMinRelayFee sets the fee rate, in base units per encoded byte, below
+// which transactions are refused.
+func (bc *BlockChain) SetMinRelayFee(rate utils.Amount) {
+	bc.mux.Lock()
+	defer bc.mux.Unlock()
+	bc.minRelayFee = rate
+}
+
+func (bc *BlockChain) MinRelayFee() utils.Amount {
+	bc.mux.Lock()
+	defer bc.mux.Unlock()
+	return bc.minRelayFee
+}
+
+// SetMempoolSize bounds the number of pending transactions. Lowering it
+// does not evict what is already pending.
+func (bc *BlockChain) SetMempoolSize(size int) {
+	if size < 1 {
+		size = 1
+	}
+	bc.mux.Lock()
+	defer bc.mux.Unlock()
+	bc.transactionPool.maxSize = size
+}
@@ -0,0 +1,173 @@
+package block
+
+import (
+	"errors"
+	"goblockchain/utils"
+	"goblockchain/wallet"
+	"testing"
+)
+
+func signedTransfer(from, to *wallet.Wallet, value, fee utils.Amount, nonce uint64) *Transaction {
+	t := wallet.NewTransaction(from.PrivateKey(), from.PublicKey(), from.BlockChainAddress(), to.BlockChainAddress(), value, fee, nonce)
+	return NewTransaction(from.BlockChainAddress(), to.BlockChainAddress(), value, fee, nonce, from.PublicKey(), t.GenerateSignature())
+}
+
+// fundedParams allocates coin to each wallet in the genesis block.
+func fundedParams(wallets ...*wallet.Wallet) *ChainParams {
+	params := DefaultChainParams()
+	for _, w := range wallets {
+		params.Allocations = append(params.Allocations, GenesisAllocation{Address: w.BlockChainAddress(), Value: 1000000000})
+	}
+	return params
+}
+
+func TestNextNonceDuringMining(t *testing.T) {
+	a, b := wallet.NewWallet(), wallet.NewWallet()
+	bc := newTestChain(t, fundedParams(a))
+	for nonce := uint64(0); nonce < 3; nonce++ {
+		if err := bc.ReceiveTransaction(signedTransfer(a, b, 1000, 100000, nonce), 1, ""); err != nil {
+			t.Fatal(err)
+		}
+	}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		bc.Mining()
+	}()
+	for running := true; running; {
+		select {
+		case <-done:
+			running = false
+		default:
+		}
+		if nonce := bc.NextNonce(a.BlockChainAddress()); nonce != 3 {
+			t.Fatalf("next nonce = %d, want 3", nonce)
+		}
+	}
+	if n := bc.ConfirmedNonce(a.BlockChainAddress()); n != 3 {
+		t.Fatalf("confirmed nonce = %d, want 3", n)
+	}
+}
+
+func TestConnectedBlockRechecksOnlyTouchedSenders(t *testing.T) {
+	a, b, c := wallet.NewWallet(), wallet.NewWallet(), wallet.NewWallet()
+	params := fundedParams(a, b)
+	miner := newTestChain(t, params)
+	if err := miner.ReceiveTransaction(signedTransfer(a, c, 1000, 100000, 0), 1, ""); err != nil {
+		t.Fatal(err)
+	}
+	mined := mineBlocks(t, miner, 1)[0]
+
+	bc := newTestChain(t, params)
+	for _, tx := range []*Transaction{
+		signedTransfer(a, c, 2000, 100000, 0),
+		signedTransfer(a, c, 3000, 100000, 1),
+		signedTransfer(b, c, 1000, 100000, 0),
+	} {
+		if err := bc.ReceiveTransaction(tx, 1, ""); err != nil {
+			t.Fatal(err)
+		}
+	}
+	untouched := bc.transactionPool.from(b.BlockChainAddress())[0]
+	if err := bc.ReceiveBlock(mined, ""); err != nil {
+		t.Fatal(err)
+	}
+
+	bc.mux.Lock()
+	defer bc.mux.Unlock()
+	if got := bc.transactionPool.from(b.BlockChainAddress()); len(got) != 1 || got[0] != untouched {
+		t.Fatal("transaction of a sender the block did not touch was rechecked")
+	}
+	got := bc.transactionPool.from(a.BlockChainAddress())
+	if len(got) != 1 || got[0].tx.nonce != 1 {
+		t.Fatalf("pending transactions of the block's sender = %d, want only nonce 1", len(got))
+	}
+	if bc.transactionPool.Len() != 2 {
+		t.Fatalf("pool holds %d transactions, want 2", bc.transactionPool.Len())
+	}
+}
+
+func TestIndependentEntriesAreSelectedAndEvictedAlone(t *testing.T) {
+	sender := wallet.NewWallet().BlockChainAddress()
+	entry := func(index uint32, fee utils.Amount, size uint64) *poolEntry {
+		inputs := []utils.OutPoint{{TxID: vectorHash(0x22), Index: index}}
+		outputs := []utils.TxOutput{{Address: sender, Value: 1000}}
+		return &poolEntry{tx: NewUTXOTransaction(sender, inputs, outputs, fee, nil, nil), size: size}
+	}
+	oversized, small, cheap := entry(0, 5000, 500), entry(1, 1000, 100), entry(2, 10, 100)
+	for _, independent := range []bool{false, true} {
+		p := newMempool(3, independent)
+		for _, e := range []*poolEntry{oversized, cheap, small} {
+			p.add(e)
+		}
+		selected := p.selectTransactions(300, 10)
+		if independent && (len(selected) != 2 || selected[0] != small.tx || selected[1] != cheap.tx) {
+			t.Fatalf("independent: selected %d transactions, want the two that fit", len(selected))
+		}
+		if !independent && len(selected) != 0 {
+			t.Fatalf("queued: selected %d transactions behind an oversized one, want 0", len(selected))
+		}
+		victim := p.evictionCandidate("")
+		if independent && victim != cheap {
+			t.Fatal("independent: the cheapest entry is not the eviction candidate")
+		}
+		if !independent && victim != small {
+			t.Fatal("queued: the sender's last entry is not the eviction candidate")
+		}
+	}
+}
+
+func TestFeeBelowRelayFloorRejected(t *testing.T) {
+	a, b := wallet.NewWallet(), wallet.NewWallet()
+	bc := newTestChain(t, fundedParams(a))
+	bc.SetMinRelayFee(10)
+	floor := 10 * utils.Amount(signedTransfer(a, b, 1000, 0, 0).Size())
+	if err := bc.ReceiveTransaction(signedTransfer(a, b, 1000, floor-1, 0), 1, ""); !errors.Is(err, ErrFeeTooLow) {
+		t.Fatalf("fee one below the floor: %v, want %v", err, ErrFeeTooLow)
+	}
+	if err := bc.ReceiveTransaction(signedTransfer(a, b, 1000, floor, 0), 1, ""); err != nil {
+		t.Fatalf("fee at the floor: %v", err)
+	}
+}
+
+func TestEvictionKeepsSenderNonceOrder(t *testing.T) {
+	a, b, c, d := wallet.NewWallet(), wallet.NewWallet(), wallet.NewWallet(), wallet.NewWallet()
+	bc := newTestChain(t, fundedParams(a, b, c))
+	bc.SetMempoolSize(3)
+	// The cheapest entry is the first of a, which the second of a depends on.
+	aFirst, aSecond := signedTransfer(a, d, 1000, 50000, 0), signedTransfer(a, d, 1000, 300000, 1)
+	bFirst := signedTransfer(b, d, 1000, 200000, 0)
+	for _, tx := range []*Transaction{aFirst, aSecond, bFirst} {
+		if err := bc.ReceiveTransaction(tx, 1, ""); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	cFirst := signedTransfer(c, d, 1000, 250000, 0)
+	if err := bc.ReceiveTransaction(cFirst, 1, ""); err != nil {
+		t.Fatalf("transaction outbidding the cheapest last entry: %v", err)
+	}
+	if err := bc.ReceiveTransaction(signedTransfer(b, d, 1000, 100000, 0), 1, ""); !errors.Is(err, ErrMempoolFull) {
+		t.Fatalf("transaction outbidding no last entry: %v, want %v", err, ErrMempoolFull)
+	}
+	// The sender's own entries are never evicted for it, even when cheaper.
+	aThird := signedTransfer(a, d, 1000, 1000000, 2)
+	if err := bc.ReceiveTransaction(aThird, 1, ""); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []*Transaction{aFirst, aSecond, aThird}
+	pool := bc.TransactionPool()
+	if len(pool) != len(want) {
+		t.Fatalf("pool holds %d transactions, want %d", len(pool), len(want))
+	}
+	for i, tx := range want {
+		if pool[i].ID() != tx.ID() {
+			t.Fatalf("pool entry %d has nonce %d from %s, want nonce %d from %s", i,
+				pool[i].nonce, pool[i].senderBlockchainAddress, tx.nonce, tx.senderBlockchainAddress)
+		}
+	}
+	if next := bc.NextNonce(a.BlockChainAddress()); next != 3 {
+		t.Fatalf("next nonce of a %d, want 3", next)
+	}
+}
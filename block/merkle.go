@@ -0,0 +1,155 @@
+package block
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+var ErrTransactionNotFound = errors.New("transaction not found")
+
+// MerkleStep is one sibling on the path from a leaf to the merkle root.
+// Left reports whether the sibling is hashed on the left.
+type MerkleStep struct {
+	Hash [32]byte
+	Left bool
+}
+
+// Leaves and inner nodes are hashed with different prefixes, so an inner
+// node can never be passed off as a leaf in a proof.
+const (
+	MERKLE_LEAF_PREFIX = 0x00
+	MERKLE_NODE_PREFIX = 0x01
+)
+
+func merkleLeaf(id [32]byte) [32]byte {
+	var buf [33]byte
+	buf[0] = MERKLE_LEAF_PREFIX
+	copy(buf[1:], id[:])
+	return sha256.Sum256(buf[:])
+}
+
+func merkleParent(left [32]byte, right [32]byte) [32]byte {
+	var buf [65]byte
+	buf[0] = MERKLE_NODE_PREFIX
+	copy(buf[1:33], left[:])
+	copy(buf[33:], right[:])
+	return sha256.Sum256(buf[:])
+}
+
+// merkleLevel hashes one level of the tree into the next. An odd last node
+// is promoted as it is rather than paired with itself, so no two lists of
+// ids share a root.
+func merkleLevel(level [][32]byte) [][32]byte {
+	next := make([][32]byte, 0, (len(level)+1)/2)
+	for i := 0; i < len(level); i += 2 {
+		if i+1 == len(level) {
+			next = append(next, level[i])
+			continue
+		}
+		next = append(next, merkleParent(level[i], level[i+1]))
+	}
+	return next
+}
+
+func merkleLeaves(ids [][32]byte) [][32]byte {
+	leaves := make([][32]byte, len(ids))
+	for i, id := range ids {
+		leaves[i] = merkleLeaf(id)
+	}
+	return leaves
+}
+
+// MerkleRoot computes the root of the tree over ids. An empty list has the
+// zero root.
+func MerkleRoot(ids [][32]byte) [32]byte {
+	if len(ids) == 0 {
+		return [32]byte{}
+	}
+	level := merkleLeaves(ids)
+	for len(level) > 1 {
+		level = merkleLevel(level)
+	}
+	return level[0]
+}
+
+// MerkleProof returns the path proving that ids[index] is part of the tree.
+// A promoted node has no sibling and adds no step.
+func MerkleProof(ids [][32]byte, index int) []MerkleStep {
+	if index < 0 || index >= len(ids) {
+		return nil
+	}
+	proof := make([]MerkleStep, 0)
+	level := merkleLeaves(ids)
+	for len(level) > 1 {
+		if sibling := index ^ 1; sibling < len(level) {
+			proof = append(proof, MerkleStep{level[sibling], sibling < index})
+		}
+		level = merkleLevel(level)
+		index /= 2
+	}
+	return proof
+}
+
+// VerifyMerkleProof reports whether proof connects id to root.
+func VerifyMerkleProof(id [32]byte, proof []MerkleStep, root [32]byte) bool {
+	hash := merkleLeaf(id)
+	for _, step := range proof {
+		if step.Left {
+			hash = merkleParent(step.Hash, hash)
+		} else {
+			hash = merkleParent(hash, step.Hash)
+		}
+	}
+	return hash == root
+}
+
+func transactionIDs(transactions []*Transaction) [][32]byte {
+	ids := make([][32]byte, len(transactions))
+	for i, t := range transactions {
+		ids[i] = t.ID()
+	}
+	return ids
+}
+
+// MerkleProofResponse proves that a transaction is part of a block whose
+// header is known to the caller.
+type MerkleProofResponse struct {
+	TransactionID [32]byte
+	BlockHash     [32]byte
+	Height        uint64
+	MerkleRoot    [32]byte
+	Index         int
+	Proof         []MerkleStep
+}
+
+func (mp *MerkleProofResponse) MarshalJSON() ([]byte, error) {
+	type step struct {
+		Hash     string `json:"hash"`
+		Position string `json:"position"`
+	}
+	steps := make([]step, len(mp.Proof))
+	for i, s := range mp.Proof {
+		position := "right"
+		if s.Left {
+			position = "left"
+		}
+		steps[i] = step{fmt.Sprintf("%x", s.Hash), position}
+	}
+	return json.Marshal(struct {
+		TransactionID string `json:"transaction_id"`
+		BlockHash     string `json:"block_hash"`
+		Height        uint64 `json:"height"`
+		MerkleRoot    string `json:"merkle_root"`
+		Index         int    `json:"index"`
+		Proof         []step `json:"proof"`
+	}{
+		TransactionID: fmt.Sprintf("%x", mp.TransactionID),
+		BlockHash:     fmt.Sprintf("%x", mp.BlockHash),
+		Height:        mp.Height,
+		MerkleRoot:    fmt.Sprintf("%x", mp.MerkleRoot),
+		Index:         mp.Index,
+		Proof:         steps,
+	})
+}
@@ -0,0 +1,57 @@
+package block
+
+import "testing"
+
+func merkleIDs(n int) [][32]byte {
+	ids := make([][32]byte, n)
+	for i := range ids {
+		ids[i] = vectorHash(byte(i + 1))
+	}
+	return ids
+}
+
+func TestMerkleProofs(t *testing.T) {
+	for n := 1; n <= 9; n++ {
+		ids := merkleIDs(n)
+		root := MerkleRoot(ids)
+		for i, id := range ids {
+			if !VerifyMerkleProof(id, MerkleProof(ids, i), root) {
+				t.Fatalf("%d ids: proof of id %d does not verify", n, i)
+			}
+		}
+	}
+	if proof := MerkleProof(merkleIDs(3), 3); proof != nil {
+		t.Fatal("proof for an index out of range")
+	}
+}
+
+func TestMerkleProofRejections(t *testing.T) {
+	ids := merkleIDs(4)
+	root := MerkleRoot(ids)
+	proof := MerkleProof(ids, 1)
+
+	if VerifyMerkleProof(ids[2], proof, root) {
+		t.Fatal("proof accepted for another id")
+	}
+	flipped := append([]MerkleStep(nil), proof...)
+	flipped[0].Left = !flipped[0].Left
+	if VerifyMerkleProof(ids[1], flipped, root) {
+		t.Fatal("proof accepted with a sibling on the wrong side")
+	}
+	// The inner node over ids 0 and 1 with the proof of its parent must
+	// not pass as a leaf.
+	inner := merkleParent(merkleLeaf(ids[0]), merkleLeaf(ids[1]))
+	if VerifyMerkleProof(inner, proof[1:], root) {
+		t.Fatal("inner node accepted as a leaf")
+	}
+}
+
+func TestMerkleRootDoesNotDuplicateOddNode(t *testing.T) {
+	ids := merkleIDs(3)
+	if MerkleRoot(ids) == MerkleRoot(append(ids, ids[2])) {
+		t.Fatal("repeating the last id keeps the root")
+	}
+	if MerkleRoot(ids[:1]) == ids[0] {
+		t.Fatal("a single id is its own root")
+	}
+}
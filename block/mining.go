@@ -0,0 +1,247 @@
+package block
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"goblockchain/utils"
+	"log"
+	"math"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// MINING_RESTART_POOL_DELTA is how many transactions have to arrive
+	// while hashing before the miner rebuilds its template to include them.
+	MINING_RESTART_POOL_DELTA = 5
+	// MINING_CANCEL_CHECK_INTERVAL is how many nonces are tried between
+	// checks for cancellation.
+	MINING_CANCEL_CHECK_INTERVAL = 256
+	// MAX_HASHRATE_MEASURE_SEC bounds how long a hashrate measurement
+	// keeps the workers busy.
+	MAX_HASHRATE_MEASURE_SEC = 10
+)
+
+var (
+	ErrMiningBusy      = errors.New("the miner is busy mining or measuring")
+	ErrMeasureDuration = errors.New("measurement duration is out of range")
+)
+
+func (bc *BlockChain) ValidProof(header *BlockHeader) bool {
+	return HashMeetsTarget(header.Hash(), header.bits)
+}
+
+// MiningStats reports the miner's configuration and measured speed.
+type MiningStats struct {
+	Workers     int     `json:"workers"`
+	Hashrate    float64 `json:"hashrate"`
+	TotalHashes uint64  `json:"total_hashes"`
+}
+
+func (bc *BlockChain) SetMiningWorkers(workers int) {
+	if workers < 1 {
+		workers = 1
+	}
+	atomic.StoreInt32(&bc.miningWorkers, int32(workers))
+}
+
+func (bc *BlockChain) MiningWorkers() int {
+	return int(atomic.LoadInt32(&bc.miningWorkers))
+}
+
+// MiningStats returns the hashrate of the most recent proof of work, in
+// hashes per second, and the number of hashes tried since startup.
+func (bc *BlockChain) MiningStats() *MiningStats {
+	return &MiningStats{
+		Workers:     bc.MiningWorkers(),
+		Hashrate:    math.Float64frombits(atomic.LoadUint64(&bc.hashrate)),
+		TotalHashes: atomic.LoadUint64(&bc.totalHashes),
+	}
+}
+
+func (bc *BlockChain) recordHashes(hashes uint64, elapsed time.Duration) {
+	atomic.AddUint64(&bc.totalHashes, hashes)
+	if elapsed > 0 {
+		rate := float64(hashes) / elapsed.Seconds()
+		atomic.StoreUint64(&bc.hashrate, math.Float64bits(rate))
+	}
+}
+
+// ProofOfWork searches for the nonce that makes b's header hash meet its
+// target, giving up with the context's error once ctx is cancelled. Each
+// worker owns the nonces congruent to its index modulo the worker count
+// and only rewrites the nonce bytes of its own copy of the serialized
+// header between attempts.
+func (bc *BlockChain) ProofOfWork(ctx context.Context, b *Block) (uint64, error) {
+	workers := bc.MiningWorkers()
+	var target [32]byte
+	CompactToTarget(b.header.bits).FillBytes(target[:])
+	header := b.header.Bytes()
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	found := make(chan uint64, workers)
+	var hashes uint64
+	var wg sync.WaitGroup
+	start := time.Now()
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func(nonce uint64) {
+			defer wg.Done()
+			buf := make([]byte, len(header))
+			copy(buf, header)
+			var count uint64
+			for {
+				binary.BigEndian.PutUint64(buf[HEADER_NONCE_OFFSET:], nonce)
+				hash := sha256.Sum256(buf)
+				count++
+				if bytes.Compare(hash[:], target[:]) <= 0 {
+					found <- nonce
+					break
+				}
+				if count%MINING_CANCEL_CHECK_INTERVAL == 0 && ctx.Err() != nil {
+					break
+				}
+				nonce += uint64(workers)
+			}
+			atomic.AddUint64(&hashes, count)
+		}(uint64(i))
+	}
+
+	var nonce uint64
+	var err error
+	select {
+	case nonce = <-found:
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+	cancel()
+	wg.Wait()
+	bc.recordHashes(hashes, time.Since(start))
+	return nonce, err
+}
+
+// MeasureHashrate runs the miner's workers against an unreachable target
+// for duration, at most MAX_HASHRATE_MEASURE_SEC, and returns the hashes
+// per second they achieved. It shares the workers with Mining, so it
+// fails with ErrMiningBusy while a block or another measurement is being
+// worked on.
+func (bc *BlockChain) MeasureHashrate(duration time.Duration) (float64, error) {
+	if duration <= 0 || duration > MAX_HASHRATE_MEASURE_SEC*time.Second {
+		return 0, ErrMeasureDuration
+	}
+	if !bc.muxMining.TryLock() {
+		return 0, ErrMiningBusy
+	}
+	defer bc.muxMining.Unlock()
+	b := NewBlock(0, [32]byte{}, 0, []*Transaction{}, 0)
+	ctx, cancel := context.WithTimeout(context.Background(), duration)
+	defer cancel()
+	_, _ = bc.ProofOfWork(ctx, b)
+	return bc.MiningStats().Hashrate, nil
+}
+
+// maxCoinbaseSize bounds the encoded size of any coinbase: the longest
+// base58 address of 25 bytes receiving the largest possible amount.
+var maxCoinbaseSize = NewCoinbase(strings.Repeat("z", 35), math.MaxUint64, math.MaxUint64).Size()
+
+// blockTemplate builds the next block from the best paying pool
+// transactions that fit the block limits plus a coinbase paying the block
+// reward and their fees to the miner, and arms the
+// cancellation that poolChanged and tip changes fire. An empty pool still
+// yields a block, since the coinbase is the only source of new coins.
+func (bc *BlockChain) blockTemplate() (context.Context, *Block, bool) {
+	bc.mux.Lock()
+	defer bc.mux.Unlock()
+	transactions := bc.transactionPool.selectTransactions(
+		bc.params.MaxBlockSize-HEADER_SIZE-maxCoinbaseSize, bc.params.MaxBlockTransactions-1)
+	reward := bc.params.Subsidy(len(bc.chain), bc.IssuedSupply())
+	var err error
+	for _, t := range transactions {
+		if reward, err = utils.AddAmount(reward, t.fee); err != nil {
+			log.Printf("ERROR: %v", err)
+			return nil, nil, false
+		}
+	}
+	height := uint64(len(bc.chain))
+	transactions = append([]*Transaction{NewCoinbase(bc.blockhainAddress, reward, height)}, transactions...)
+	headers := headersOf(bc.chain)
+	timestamp := bc.clock().UnixNano()
+	if mtp := bc.params.MedianTimePast(headers); timestamp <= mtp {
+		timestamp = mtp + 1
+	}
+	b := NewBlock(height, bc.LastBlock().Hash(), timestamp, transactions, bc.params.NextBits(headers))
+	if b.header.stateRoot, err = bc.state.rootAfter(int(height), b); err != nil {
+		log.Printf("ERROR: %v", err)
+		return nil, nil, false
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	bc.miningCancel = cancel
+	bc.miningPoolSize = bc.transactionPool.Len()
+	return ctx, b, true
+}
+
+// cancelMining aborts the proof of work in progress, if any. The caller
+// must hold bc.mux.
+func (bc *BlockChain) cancelMining() {
+	if bc.miningCancel != nil {
+		bc.miningCancel()
+		bc.miningCancel = nil
+	}
+}
+
+// poolChanged restarts mining once enough new transactions have arrived to
+// be worth a fresh template. The caller must hold bc.mux.
+func (bc *BlockChain) poolChanged() {
+	if bc.miningCancel != nil && bc.transactionPool.Len()-bc.miningPoolSize >= MINING_RESTART_POOL_DELTA {
+		log.Printf("action=mining, status=restart, reason=pool_changed")
+		bc.cancelMining()
+	}
+}
+
+// Mining hashes on a template of the current tip without holding the chain
+// lock, so consensus can move the tip meanwhile; when it does, or the pool
+// grows enough, the work is abandoned and restarted on a new template.
+func (bc *BlockChain) Mining() bool {
+	bc.muxMining.Lock()
+	defer bc.muxMining.Unlock()
+
+	for {
+		ctx, b, ok := bc.blockTemplate()
+		if !ok {
+			return false
+		}
+		nonce, err := bc.ProofOfWork(ctx, b)
+		if err != nil {
+			continue
+		}
+		b.header.nonce = nonce
+
+		bc.mux.Lock()
+		bc.cancelMining()
+		if bc.LastBlock().Hash() != b.header.previousHash {
+			bc.mux.Unlock()
+			log.Printf("action=mining, status=restart, reason=stale_tip")
+			continue
+		}
+		created := bc.CreateBlock(b) != nil
+		bc.mux.Unlock()
+		if !created {
+			log.Println("action=mining, status=fail")
+			return false
+		}
+		log.Printf("action=mining, status=success, height=%d, hashrate=%.0f", b.header.height, bc.MiningStats().Hashrate)
+		bc.relayBlock(b.Hash(), "")
+		return true
+	}
+}
+
+func (bc *BlockChain) StartMining() {
+	bc.Mining()
+	_ = time.AfterFunc(time.Second*MINING_TIMER_SEC, bc.StartMining)
+}
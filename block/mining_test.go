@@ -0,0 +1,69 @@
+package block
+
+import (
+	"context"
+	"errors"
+	"goblockchain/wallet"
+	"testing"
+	"time"
+)
+
+func TestMeasureHashrateLimits(t *testing.T) {
+	bc := newTestChain(t, DefaultChainParams())
+	for _, d := range []time.Duration{0, MAX_HASHRATE_MEASURE_SEC*time.Second + 1} {
+		if _, err := bc.MeasureHashrate(d); !errors.Is(err, ErrMeasureDuration) {
+			t.Fatalf("measuring for %v: %v, want %v", d, err, ErrMeasureDuration)
+		}
+	}
+
+	bc.muxMining.Lock()
+	_, err := bc.MeasureHashrate(10 * time.Millisecond)
+	bc.muxMining.Unlock()
+	if !errors.Is(err, ErrMiningBusy) {
+		t.Fatalf("measuring while mining: %v, want %v", err, ErrMiningBusy)
+	}
+	if _, err := bc.MeasureHashrate(10 * time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestMiningRestartsOnNewTipOrPoolGrowth(t *testing.T) {
+	a, b := wallet.NewWallet(), wallet.NewWallet()
+	params := fundedParams(a)
+	bc := newTestChain(t, params)
+
+	ctx, _, ok := bc.blockTemplate()
+	if !ok {
+		t.Fatal("no block template")
+	}
+	for nonce := uint64(0); nonce < MINING_RESTART_POOL_DELTA; nonce++ {
+		if ctx.Err() != nil {
+			t.Fatalf("mining restarted after %d new transactions, want %d", nonce, MINING_RESTART_POOL_DELTA)
+		}
+		if err := bc.ReceiveTransaction(signedTransfer(a, b, 1000, 100000, nonce), 1, ""); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if !errors.Is(ctx.Err(), context.Canceled) {
+		t.Fatalf("mining not restarted after %d new transactions", MINING_RESTART_POOL_DELTA)
+	}
+	ctx, template, ok := bc.blockTemplate()
+	if !ok {
+		t.Fatal("no block template")
+	}
+	if n := len(template.transactions); n != MINING_RESTART_POOL_DELTA+1 {
+		t.Fatalf("restarted template holds %d transactions, want the coinbase and the new ones", n)
+	}
+
+	other := newTestChain(t, params)
+	tip := mineBlocks(t, other, 1)[0]
+	if err := bc.ReceiveBlock(tip, ""); err != nil {
+		t.Fatal(err)
+	}
+	if !errors.Is(ctx.Err(), context.Canceled) {
+		t.Fatal("mining not restarted on a new tip")
+	}
+	if _, template, _ = bc.blockTemplate(); template.header.previousHash != tip.Hash() {
+		t.Fatalf("restarted template builds on %x, want the new tip %x", template.header.previousHash, tip.Hash())
+	}
+}
@@ -0,0 +1,335 @@
+package block
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"time"
+)
+
+const (
+	// MAX_ORPHAN_BLOCKS bounds the orphan pool. A block further than this
+	// above the tip is not pooled; the chain is synced from its sender
+	// instead, at most once every SYNC_TRIGGER_INTERVAL_SEC.
+	MAX_ORPHAN_BLOCKS         = 100
+	SYNC_TRIGGER_INTERVAL_SEC = 30
+)
+
+var (
+	ErrKnownBlock  = errors.New("block already known")
+	ErrOrphanBlock = errors.New("parent block is unknown")
+	ErrSideBranch  = errors.New("block is on a branch with less work")
+)
+
+type orphanEntry struct {
+	block *Block
+	// peer is where the block came from and where its parent is asked
+	// for first.
+	peer string
+}
+
+// orphanPool holds received blocks that are not on the local chain: those
+// whose parent has not arrived yet and those on a branch with less work.
+// When full, the block that arrived first is evicted.
+type orphanPool struct {
+	entries   map[[32]byte]*orphanEntry
+	order     [][32]byte
+	requested map[[32]byte]bool
+	maxSize   int
+}
+
+func newOrphanPool(maxSize int) *orphanPool {
+	return &orphanPool{
+		entries:   make(map[[32]byte]*orphanEntry),
+		requested: make(map[[32]byte]bool),
+		maxSize:   maxSize,
+	}
+}
+
+func (p *orphanPool) Len() int {
+	return len(p.entries)
+}
+
+func (p *orphanPool) get(hash [32]byte) *orphanEntry {
+	return p.entries[hash]
+}
+
+func (p *orphanPool) add(b *Block, peer string) {
+	hash := b.Hash()
+	if _, ok := p.entries[hash]; ok {
+		return
+	}
+	for len(p.entries) >= p.maxSize && len(p.order) > 0 {
+		p.remove(p.order[0])
+	}
+	p.entries[hash] = &orphanEntry{b, peer}
+	p.order = append(p.order, hash)
+}
+
+func (p *orphanPool) remove(hash [32]byte) {
+	if _, ok := p.entries[hash]; !ok {
+		return
+	}
+	delete(p.entries, hash)
+	for i, h := range p.order {
+		if h == hash {
+			p.order = append(p.order[:i], p.order[i+1:]...)
+			break
+		}
+	}
+}
+
+// removeBranch drops the block with hash and every pooled descendant of
+// it, which can never connect once it turned out invalid.
+func (p *orphanPool) removeBranch(hash [32]byte) {
+	p.remove(hash)
+	for _, child := range p.children(hash) {
+		p.removeBranch(child.Hash())
+	}
+}
+
+func (p *orphanPool) children(hash [32]byte) []*Block {
+	children := make([]*Block, 0)
+	for _, h := range p.order {
+		if b := p.entries[h].block; b.header.previousHash == hash {
+			children = append(children, b)
+		}
+	}
+	return children
+}
+
+// bestDescendants is the path of pooled blocks on top of hash with the
+// most work, empty when no pooled block builds on it.
+func (p *orphanPool) bestDescendants(hash [32]byte) []*Block {
+	best := make([]*Block, 0)
+	bestWork := ChainWork(best)
+	for _, child := range p.children(hash) {
+		path := append([]*Block{child}, p.bestDescendants(child.Hash())...)
+		if work := ChainWork(path); work.Cmp(bestWork) > 0 {
+			best, bestWork = path, work
+		}
+	}
+	return best
+}
+
+// markRequested reports whether hash still has to be asked for, and
+// notes that it now is.
+func (p *orphanPool) markRequested(hash [32]byte) bool {
+	if p.requested[hash] {
+		return false
+	}
+	p.requested[hash] = true
+	return true
+}
+
+// AcceptBlock takes a single block from peer, which may be empty for a
+// block of unknown origin. A block on top of the tip is connected at once.
+// A block whose parent is unknown waits in the orphan pool while the parent
+// is requested, first from peer and then from the neighbors; once the
+// missing ancestors arrive, the branch they complete is connected, and
+// replaces the local chain if it carries more work.
+func (bc *BlockChain) AcceptBlock(b *Block, peer string) error {
+	bc.mux.Lock()
+	defer bc.mux.Unlock()
+	hash := b.Hash()
+	if bc.orphans.get(hash) != nil {
+		return ErrKnownBlock
+	}
+	if _, err := bc.store.BlockByHash(hash); err == nil {
+		return ErrKnownBlock
+	}
+	height := b.header.height
+	if height == 0 {
+		return fmt.Errorf("block 0: %w", ErrGenesisMismatch)
+	}
+	if err := bc.params.checkBits(b.header.bits); err != nil {
+		return fmt.Errorf("block %d: %w", height, err)
+	}
+	if err := bc.checkNearTipBits(b.Header()); err != nil {
+		return err
+	}
+	if !bc.ValidProof(b.Header()) {
+		return fmt.Errorf("block %d: invalid proof of work", height)
+	}
+	if err := validBlockStructure(b, int(height), bc.params); err != nil {
+		return err
+	}
+	if tip := bc.LastBlock().header.height; height > tip+MAX_ORPHAN_BLOCKS {
+		if now := bc.clock(); peer != "" && now.Sub(bc.lastSyncTrigger) >= SYNC_TRIGGER_INTERVAL_SEC*time.Second {
+			bc.lastSyncTrigger = now
+			go func() {
+				if err := bc.syncFrom(peer); err != nil {
+					log.Printf("action=accept_block, peer=%s, status=sync_failed, reason=%v", peer, err)
+				}
+			}()
+		}
+		return fmt.Errorf("%w: block %d is too far ahead of tip %d", ErrOrphanBlock, height, tip)
+	}
+	bc.orphans.add(b, peer)
+	return bc.connectOrphans(hash)
+}
+
+// checkNearTipBits rejects a block whose target is easier than a branch
+// near the tip could require at its height. Until its parent is known the
+// bits of an orphan cannot be checked exactly, so it is held to the target
+// expected after the tip, eased by the largest retarget step once for a
+// retarget the branch may have made differently and once more for every
+// retarget up to its height. The caller must hold bc.mux.
+func (bc *BlockChain) checkNearTipBits(h *BlockHeader) error {
+	next := uint64(len(bc.chain))
+	window := uint64(bc.params.RetargetWindow)
+	steps := uint64(1)
+	if h.height > next {
+		steps += h.height/window - next/window
+	}
+	if steps > 128 {
+		steps = 128
+	}
+	easiest := CompactToTarget(bc.params.NextBits(headersOf(bc.chain)))
+	easiest.Lsh(easiest, uint(2*steps))
+	if CompactToTarget(h.bits).Cmp(easiest) > 0 {
+		return fmt.Errorf("block %d: %w: target %08x is far easier than the chain's", h.height, ErrInvalidBits, h.bits)
+	}
+	return nil
+}
+
+// connectOrphans follows the pooled block with hash back to the local
+// chain and connects the branch it belongs to if that branch has more work
+// than the chain, and returns ErrSideBranch if it has not. The caller must
+// hold bc.mux.
+func (bc *BlockChain) connectOrphans(hash [32]byte) error {
+	entry := bc.orphans.get(hash)
+	branch := []*Block{entry.block}
+	ancestor := -1
+	for {
+		first := branch[0]
+		if parent, err := bc.store.BlockByHash(first.header.previousHash); err == nil {
+			ancestor = int(parent.header.height)
+			break
+		}
+		parent := bc.orphans.get(first.header.previousHash)
+		if parent == nil {
+			missing := first.header.previousHash
+			if bc.orphans.markRequested(missing) {
+				go bc.requestBlock(missing, bc.orphans.get(first.Hash()).peer)
+			}
+			log.Printf("action=accept_block, hash=%x, status=orphan, missing=%x, orphans=%d",
+				hash, missing, bc.orphans.Len())
+			return fmt.Errorf("%w: %x", ErrOrphanBlock, missing)
+		}
+		branch = append([]*Block{parent.block}, branch...)
+	}
+	branch = append(branch, bc.orphans.bestDescendants(hash)...)
+	candidate := append(append(make([]*Block, 0, ancestor+1+len(branch)), bc.chain[:ancestor+1]...), branch...)
+	if ChainWork(candidate).Cmp(ChainWork(bc.chain)) <= 0 {
+		log.Printf("action=accept_block, hash=%x, status=side_branch, ancestor=%d", hash, ancestor)
+		return ErrSideBranch
+	}
+
+	if ancestor == len(bc.chain)-1 {
+		defer bc.cancelMining()
+		for _, b := range branch {
+			peer := bc.orphans.get(b.Hash()).peer
+			if err := bc.appendBlock(b); err != nil {
+				bc.orphans.removeBranch(b.Hash())
+				return err
+			}
+			bc.orphans.remove(b.Hash())
+			bc.relayBlock(b.Hash(), peer)
+			log.Printf("action=accept_block, hash=%x, status=connected, height=%d", b.Hash(), b.header.height)
+		}
+		return nil
+	}
+	peers := make([]string, len(branch))
+	for i, b := range branch {
+		peers[i] = bc.orphans.get(b.Hash()).peer
+	}
+	if bad, err := bc.validBranch(ancestor, branch); err != nil {
+		bc.orphans.removeBranch(branch[bad].Hash())
+		return err
+	}
+	for _, b := range branch {
+		bc.orphans.remove(b.Hash())
+	}
+	if err := bc.reorganize(candidate); err != nil {
+		return err
+	}
+	for i, b := range branch {
+		bc.relayBlock(b.Hash(), peers[i])
+	}
+	return nil
+}
+
+// stateAt is a copy of the state index as it was after the block at
+// height ancestor, for validating a branch that forks there. The caller
+// must hold bc.mux.
+func (bc *BlockChain) stateAt(ancestor int) *chainState {
+	state := bc.state.clone()
+	for height := len(bc.chain) - 1; height > ancestor; height-- {
+		state.disconnectBlock(bc.undo[height])
+	}
+	return state
+}
+
+// validBranch checks branch, which forks off the chain above height
+// ancestor, on top of a copy of the state at the fork point, so only the
+// blocks of the branch are validated. On failure it returns the index of
+// the invalid block. The caller must hold bc.mux.
+func (bc *BlockChain) validBranch(ancestor int, branch []*Block) (int, error) {
+	state := bc.stateAt(ancestor)
+	headers := headersOf(bc.chain[:ancestor+1])
+	for i, b := range branch {
+		height := ancestor + 1 + i
+		if err := bc.validHeader(headers, b.Header()); err != nil {
+			return i, err
+		}
+		if err := validBlockStructure(b, height, bc.params); err != nil {
+			return i, err
+		}
+		if _, err := state.connectBlock(height, b); err != nil {
+			return i, err
+		}
+		headers = append(headers, b.Header())
+	}
+	return 0, nil
+}
+
+// requestBlock asks peer and then the neighbors for the block with hash
+// and accepts the first copy that matches.
+func (bc *BlockChain) requestBlock(hash [32]byte, peer string) {
+	defer func() {
+		bc.mux.Lock()
+		delete(bc.orphans.requested, hash)
+		bc.mux.Unlock()
+	}()
+	neighbors := bc.Neighbors()
+	peers := make([]string, 0, len(neighbors)+1)
+	if peer != "" {
+		peers = append(peers, peer)
+	}
+	for _, n := range neighbors {
+		if n != peer {
+			peers = append(peers, n)
+		}
+	}
+	for _, p := range peers {
+		var br BlocksResponse
+		if err := getJSON(fmt.Sprintf("http://%s/blocks?hash=%x", p, hash), &br); err != nil {
+			continue
+		}
+		if len(br.Blocks) != 1 || br.Blocks[0].Hash() != hash {
+			continue
+		}
+		err := bc.AcceptBlock(br.Blocks[0], p)
+		if err != nil && !errors.Is(err, ErrOrphanBlock) && !errors.Is(err, ErrKnownBlock) && !errors.Is(err, ErrSideBranch) {
+			log.Printf("action=request_block, hash=%x, peer=%s, status=rejected, reason=%v", hash, p, err)
+		}
+		return
+	}
+	log.Printf("action=request_block, hash=%x, status=not_found", hash)
+}
+
+// BlockByHash returns the block with hash from the local chain.
+func (bc *BlockChain) BlockByHash(hash [32]byte) (*Block, error) {
+	return bc.store.BlockByHash(hash)
+}
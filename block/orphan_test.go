@@ -0,0 +1,74 @@
+package block
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+// remine copies b with a state root that does not match its transactions
+// and a fresh proof of work.
+func remine(t *testing.T, bc *BlockChain, b *Block) *Block {
+	t.Helper()
+	bad := NewBlock(b.header.height, b.header.previousHash, b.header.timestamp, b.transactions, b.header.bits)
+	bad.header.stateRoot = vectorHash(0xee)
+	nonce, err := bc.ProofOfWork(context.Background(), bad)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bad.header.nonce = nonce
+	return bad
+}
+
+func TestSideBranchTakesOver(t *testing.T) {
+	params := DefaultChainParams()
+	bc := newTestChain(t, params)
+	local := mineBlocks(t, bc, 1)[0]
+	other := newTestChain(t, params)
+	branch := mineBlocks(t, other, 2)
+
+	if err := bc.ReceiveBlock(branch[0], ""); !errors.Is(err, ErrSideBranch) {
+		t.Fatalf("branch with equal work: %v, want %v", err, ErrSideBranch)
+	}
+	if tip := bc.Tip(); tip.Hash != local.Hash() {
+		t.Fatal("branch with equal work replaced the chain")
+	}
+	if err := bc.ReceiveBlock(remine(t, other, branch[1]), ""); !errors.Is(err, ErrStateRootMismatch) {
+		t.Fatalf("invalid branch: %v, want %v", err, ErrStateRootMismatch)
+	}
+	if tip := bc.Tip(); tip.Hash != local.Hash() {
+		t.Fatal("invalid branch replaced the chain")
+	}
+
+	if err := bc.ReceiveBlock(branch[1], ""); err != nil {
+		t.Fatal(err)
+	}
+	if tip := bc.Tip(); tip.Hash != branch[1].Hash() {
+		t.Fatalf("tip %x, want the branch tip %x", tip.Hash, branch[1].Hash())
+	}
+	sc, err := bc.CheckState()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !sc.Consistent() {
+		t.Fatalf("state after reorg: %v", sc.Mismatches)
+	}
+}
+
+func TestOrphanBitsMustBeNearTheTip(t *testing.T) {
+	params := DefaultChainParams()
+	bc := newTestChain(t, params)
+
+	cheap := NewBlock(5, vectorHash(0x33), 0, []*Transaction{NewCoinbase(bc.blockhainAddress, 1, 5)}, params.PowLimitBits)
+	nonce, err := bc.ProofOfWork(context.Background(), cheap)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cheap.header.nonce = nonce
+	if err := bc.ReceiveBlock(cheap, ""); !errors.Is(err, ErrInvalidBits) {
+		t.Fatalf("orphan at the pow limit: %v, want %v", err, ErrInvalidBits)
+	}
+	if n := bc.orphans.Len(); n != 0 {
+		t.Fatalf("orphan pool holds %d blocks, want 0", n)
+	}
+}
@@ -0,0 +1,393 @@
+package block
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"goblockchain/utils"
+	"goblockchain/wallet"
+	"os"
+	"time"
+)
+
+const (
+	DEFAULT_NETWORK_ID = "goblockchain-dev"
+	// GENESIS_TIMESTAMP is 2024-01-01T00:00:00Z in nanoseconds, the unit
+	// of block timestamps.
+	GENESIS_TIMESTAMP = 1704067200000000000
+)
+
+// GenesisAllocation credits Value to Address in the genesis block.
+type GenesisAllocation struct {
+	Address string
+	Value   utils.Amount
+}
+
+// ChainParams holds the consensus rules every node on a network has to
+// agree on, together with the genesis block they start from and where
+// nodes look for each other. A network is shared by loading the same
+// params file with LoadChainParams.
+type ChainParams struct {
+	// NetworkID names the network, so nodes can tell why a peer's genesis
+	// differs.
+	NetworkID string
+	// GenesisTimestamp is the timestamp of the genesis block, which like
+	// every other field of it is fixed so all nodes build the same one.
+	GenesisTimestamp int64
+	// Allocations are paid out by the genesis block.
+	Allocations []GenesisAllocation
+	// PowLimitBits is the easiest target a block may use.
+	PowLimitBits uint32
+	// GenesisBits is the target of the genesis block and of every block
+	// before the first retarget.
+	GenesisBits uint32
+	// BlockIntervalSec is the block time the retarget aims for.
+	BlockIntervalSec int64
+	// RetargetWindow is the number of blocks between retargets.
+	RetargetWindow int
+	// MedianTimeSpan is the number of previous blocks whose median
+	// timestamp a new block has to be dated after.
+	MedianTimeSpan int
+	// MaxFutureDriftSec is how far ahead of the local clock a block may
+	// be dated.
+	MaxFutureDriftSec int64
+	// Decimals is the number of decimal places of a coin; amounts are
+	// kept as integers of the smallest unit.
+	Decimals uint8
+	// InitialReward is the subsidy of a block before the first halving,
+	// in base units.
+	InitialReward utils.Amount
+	// HalvingInterval is the number of blocks after which the subsidy
+	// halves; zero keeps it constant.
+	HalvingInterval int
+	// MaxSupply caps the total subsidy ever paid out.
+	MaxSupply utils.Amount
+	// Ledger is LEDGER_ACCOUNT, where transactions move value between
+	// address balances, or LEDGER_UTXO, where they spend and create
+	// outputs. It is fixed at genesis.
+	Ledger string
+	// MaxBlockSize bounds Block.Size.
+	MaxBlockSize uint64
+	// MaxBlockTransactions bounds the transactions of a block, the
+	// coinbase included.
+	MaxBlockTransactions int
+	// PortRangeStart and PortRangeEnd are the ports scanned for
+	// neighbors, on the hosts NeighborIPRangeStart to NeighborIPRangeEnd
+	// above the node's own address.
+	PortRangeStart       uint16
+	PortRangeEnd         uint16
+	NeighborIPRangeStart uint8
+	NeighborIPRangeEnd   uint8
+}
+
+func DefaultChainParams() *ChainParams {
+	return &ChainParams{
+		NetworkID:            DEFAULT_NETWORK_ID,
+		GenesisTimestamp:     GENESIS_TIMESTAMP,
+		PowLimitBits:         POW_LIMIT_BITS,
+		GenesisBits:          MINING_DIFICULTY_BITS,
+		BlockIntervalSec:     BLOCK_INTERVAL_SEC,
+		RetargetWindow:       RETARGET_WINDOW,
+		MedianTimeSpan:       MEDIAN_TIME_SPAN,
+		MaxFutureDriftSec:    MAX_FUTURE_DRIFT_SEC,
+		Decimals:             AMOUNT_DECIMALS,
+		InitialReward:        MINING_REWARD,
+		HalvingInterval:      HALVING_INTERVAL,
+		MaxSupply:            MAX_SUPPLY,
+		Ledger:               LEDGER_ACCOUNT,
+		MaxBlockSize:         MAX_BLOCK_SIZE,
+		MaxBlockTransactions: MAX_BLOCK_TRANSACTIONS,
+		PortRangeStart:       BLOCKCHAIN_PORT_RANGE_START,
+		PortRangeEnd:         BLOCKCHAIN_PORT_RANGE_END,
+		NeighborIPRangeStart: NEIGHBOR_IP_RANGE_START,
+		NeighborIPRangeEnd:   NEIGHBOR_IP_RANGE_END,
+	}
+}
+
+// LoadChainParams reads a params file. Fields missing from the file keep
+// their DefaultChainParams values.
+func LoadChainParams(path string) (*ChainParams, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	p := DefaultChainParams()
+	if err := json.Unmarshal(data, p); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	if err := p.Validate(); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return p, nil
+}
+
+func (p *ChainParams) Validate() error {
+	if p.NetworkID == "" {
+		return errors.New("chain params: network id is required")
+	}
+	if CompactToTarget(p.PowLimitBits).Sign() <= 0 {
+		return errors.New("chain params: invalid pow limit")
+	}
+	if err := p.checkBits(p.GenesisBits); err != nil {
+		return err
+	}
+	if p.BlockIntervalSec <= 0 {
+		return errors.New("chain params: block interval must be positive")
+	}
+	if p.RetargetWindow < 2 {
+		return errors.New("chain params: retarget window must be at least 2")
+	}
+	if p.MedianTimeSpan < 1 || p.MaxFutureDriftSec < 0 {
+		return errors.New("chain params: invalid timestamp rules")
+	}
+	if p.Decimals > utils.MaxAmountDecimals {
+		return errors.New("chain params: too many decimals")
+	}
+	if p.Ledger != LEDGER_ACCOUNT && p.Ledger != LEDGER_UTXO {
+		return fmt.Errorf("chain params: unknown ledger %q", p.Ledger)
+	}
+	if p.HalvingInterval < 0 {
+		return errors.New("chain params: halving interval must not be negative")
+	}
+	if p.MaxBlockTransactions < 1 || p.MaxBlockSize <= HEADER_SIZE+maxCoinbaseSize {
+		return errors.New("chain params: block limits leave no room for a coinbase")
+	}
+	if p.PortRangeStart > p.PortRangeEnd || p.NeighborIPRangeStart > p.NeighborIPRangeEnd {
+		return errors.New("chain params: empty neighbor range")
+	}
+	var allocated utils.Amount
+	seen := make(map[string]bool, len(p.Allocations))
+	for _, a := range p.Allocations {
+		if err := wallet.ValidateAddress(a.Address); err != nil {
+			return fmt.Errorf("chain params: allocation to %q: %v", a.Address, err)
+		}
+		if seen[a.Address] {
+			return fmt.Errorf("chain params: %s is allocated twice", a.Address)
+		}
+		seen[a.Address] = true
+		if a.Value == 0 {
+			return fmt.Errorf("chain params: allocation to %s must be positive", a.Address)
+		}
+		var err error
+		if allocated, err = utils.AddAmount(allocated, a.Value); err != nil {
+			return fmt.Errorf("chain params: allocations: %w", err)
+		}
+	}
+	if allocated > p.MaxSupply {
+		return errors.New("chain params: allocations exceed the maximum supply")
+	}
+	genesis, err := p.GenesisBlock()
+	if err != nil {
+		return err
+	}
+	if err := p.checkBlockLimits(genesis); err != nil {
+		return fmt.Errorf("chain params: genesis: %w", err)
+	}
+	return nil
+}
+
+// GenesisBlock builds the block every chain of the network starts with. It
+// holds one coinbase per allocation and, like any block, commits to the
+// state it leaves behind. Having no parent, it commits to the consensus
+// rules in place of the previous hash, so nodes that disagree on them
+// never share a genesis hash.
+func (p *ChainParams) GenesisBlock() (*Block, error) {
+	transactions := make([]*Transaction, len(p.Allocations))
+	for i, a := range p.Allocations {
+		transactions[i] = NewCoinbase(a.Address, a.Value, 0)
+	}
+	b := NewBlock(0, p.consensusHash(), p.GenesisTimestamp, transactions, p.GenesisBits)
+	root, err := newChainState(p).rootAfter(0, b)
+	if err != nil {
+		return nil, fmt.Errorf("chain params: genesis: %w", err)
+	}
+	b.header.stateRoot = root
+	return b, nil
+}
+
+// consensusHash digests the params that decide which blocks are valid.
+// The neighbor ranges only affect discovery and are left out, and the
+// allocations and genesis time are part of the genesis block anyway.
+func (p *ChainParams) consensusHash() [32]byte {
+	e := utils.NewEncoder()
+	e.PutString(p.NetworkID)
+	e.PutUint32(p.PowLimitBits)
+	e.PutUint32(p.GenesisBits)
+	e.PutUint64(uint64(p.BlockIntervalSec))
+	e.PutUint64(uint64(p.RetargetWindow))
+	e.PutUint64(uint64(p.MedianTimeSpan))
+	e.PutUint64(uint64(p.MaxFutureDriftSec))
+	e.PutUint8(p.Decimals)
+	e.PutAmount(p.InitialReward)
+	e.PutUint64(uint64(p.HalvingInterval))
+	e.PutAmount(p.MaxSupply)
+	e.PutString(p.Ledger)
+	e.PutUint64(p.MaxBlockSize)
+	e.PutUint64(uint64(p.MaxBlockTransactions))
+	return sha256.Sum256(e.Bytes())
+}
+
+// allocationJSON is a genesis allocation with its value as a decimal
+// string.
+type allocationJSON struct {
+	Address string `json:"address"`
+	Value   string `json:"value"`
+}
+
+func (p *ChainParams) MarshalJSON() ([]byte, error) {
+	allocations := make([]allocationJSON, len(p.Allocations))
+	for i, a := range p.Allocations {
+		allocations[i] = allocationJSON{a.Address, utils.FormatAmount(a.Value, p.Decimals)}
+	}
+	return json.Marshal(struct {
+		NetworkID            string           `json:"network_id"`
+		GenesisTime          string           `json:"genesis_time"`
+		Allocations          []allocationJSON `json:"allocations"`
+		PowLimitBits         uint32           `json:"pow_limit_bits"`
+		GenesisBits          uint32           `json:"genesis_bits"`
+		BlockIntervalSec     int64            `json:"block_interval_sec"`
+		RetargetWindow       int              `json:"retarget_window"`
+		MedianTimeSpan       int              `json:"median_time_span"`
+		MaxFutureDriftSec    int64            `json:"max_future_drift_sec"`
+		Decimals             uint8            `json:"decimals"`
+		InitialReward        string           `json:"initial_reward"`
+		HalvingInterval      int              `json:"halving_interval"`
+		MaxSupply            string           `json:"max_supply"`
+		Ledger               string           `json:"ledger"`
+		MaxBlockSize         uint64           `json:"max_block_size"`
+		MaxBlockTransactions int              `json:"max_block_transactions"`
+		PortRangeStart       uint16           `json:"port_range_start"`
+		PortRangeEnd         uint16           `json:"port_range_end"`
+		NeighborIPRangeStart uint8            `json:"neighbor_ip_range_start"`
+		NeighborIPRangeEnd   uint8            `json:"neighbor_ip_range_end"`
+	}{
+		NetworkID:            p.NetworkID,
+		GenesisTime:          time.Unix(0, p.GenesisTimestamp).UTC().Format(time.RFC3339Nano),
+		Allocations:          allocations,
+		PowLimitBits:         p.PowLimitBits,
+		GenesisBits:          p.GenesisBits,
+		BlockIntervalSec:     p.BlockIntervalSec,
+		RetargetWindow:       p.RetargetWindow,
+		MedianTimeSpan:       p.MedianTimeSpan,
+		MaxFutureDriftSec:    p.MaxFutureDriftSec,
+		Decimals:             p.Decimals,
+		InitialReward:        utils.FormatAmount(p.InitialReward, p.Decimals),
+		HalvingInterval:      p.HalvingInterval,
+		MaxSupply:            utils.FormatAmount(p.MaxSupply, p.Decimals),
+		Ledger:               p.Ledger,
+		MaxBlockSize:         p.MaxBlockSize,
+		MaxBlockTransactions: p.MaxBlockTransactions,
+		PortRangeStart:       p.PortRangeStart,
+		PortRangeEnd:         p.PortRangeEnd,
+		NeighborIPRangeStart: p.NeighborIPRangeStart,
+		NeighborIPRangeEnd:   p.NeighborIPRangeEnd,
+	})
+}
+
+// UnmarshalJSON reads amounts as decimal strings in the units set by
+// decimals, which is read first.
+func (p *ChainParams) UnmarshalJSON(data []byte) error {
+	var genesisTime, initialReward, maxSupply *string
+	var allocations *[]allocationJSON
+	v := &struct {
+		NetworkID            *string            `json:"network_id"`
+		GenesisTime          **string           `json:"genesis_time"`
+		Allocations          **[]allocationJSON `json:"allocations"`
+		PowLimitBits         *uint32            `json:"pow_limit_bits"`
+		GenesisBits          *uint32            `json:"genesis_bits"`
+		BlockIntervalSec     *int64             `json:"block_interval_sec"`
+		RetargetWindow       *int               `json:"retarget_window"`
+		MedianTimeSpan       *int               `json:"median_time_span"`
+		MaxFutureDriftSec    *int64             `json:"max_future_drift_sec"`
+		Decimals             *uint8             `json:"decimals"`
+		InitialReward        **string           `json:"initial_reward"`
+		HalvingInterval      *int               `json:"halving_interval"`
+		MaxSupply            **string           `json:"max_supply"`
+		Ledger               *string            `json:"ledger"`
+		MaxBlockSize         *uint64            `json:"max_block_size"`
+		MaxBlockTransactions *int               `json:"max_block_transactions"`
+		PortRangeStart       *uint16            `json:"port_range_start"`
+		PortRangeEnd         *uint16            `json:"port_range_end"`
+		NeighborIPRangeStart *uint8             `json:"neighbor_ip_range_start"`
+		NeighborIPRangeEnd   *uint8             `json:"neighbor_ip_range_end"`
+	}{
+		NetworkID:            &p.NetworkID,
+		GenesisTime:          &genesisTime,
+		Allocations:          &allocations,
+		PowLimitBits:         &p.PowLimitBits,
+		GenesisBits:          &p.GenesisBits,
+		BlockIntervalSec:     &p.BlockIntervalSec,
+		RetargetWindow:       &p.RetargetWindow,
+		MedianTimeSpan:       &p.MedianTimeSpan,
+		MaxFutureDriftSec:    &p.MaxFutureDriftSec,
+		Decimals:             &p.Decimals,
+		InitialReward:        &initialReward,
+		HalvingInterval:      &p.HalvingInterval,
+		MaxSupply:            &maxSupply,
+		Ledger:               &p.Ledger,
+		MaxBlockSize:         &p.MaxBlockSize,
+		MaxBlockTransactions: &p.MaxBlockTransactions,
+		PortRangeStart:       &p.PortRangeStart,
+		PortRangeEnd:         &p.PortRangeEnd,
+		NeighborIPRangeStart: &p.NeighborIPRangeStart,
+		NeighborIPRangeEnd:   &p.NeighborIPRangeEnd,
+	}
+	if err := json.Unmarshal(data, v); err != nil {
+		return err
+	}
+	if p.Decimals > utils.MaxAmountDecimals {
+		return errors.New("decimals: too many decimals")
+	}
+	if genesisTime != nil {
+		t, err := time.Parse(time.RFC3339Nano, *genesisTime)
+		if err != nil {
+			return fmt.Errorf("genesis_time: %w", err)
+		}
+		p.GenesisTimestamp = t.UnixNano()
+	}
+	var err error
+	if initialReward != nil {
+		if p.InitialReward, err = utils.ParseAmount(*initialReward, p.Decimals); err != nil {
+			return fmt.Errorf("initial_reward: %w", err)
+		}
+	}
+	if maxSupply != nil {
+		if p.MaxSupply, err = utils.ParseAmount(*maxSupply, p.Decimals); err != nil {
+			return fmt.Errorf("max_supply: %w", err)
+		}
+	}
+	if allocations != nil {
+		p.Allocations = make([]GenesisAllocation, len(*allocations))
+		for i, a := range *allocations {
+			value, err := utils.ParseAmount(a.Value, p.Decimals)
+			if err != nil {
+				return fmt.Errorf("allocations: %s: %w", a.Address, err)
+			}
+			p.Allocations[i] = GenesisAllocation{a.Address, value}
+		}
+	}
+	return nil
+}
+
+func (p *ChainParams) checkBlockLimits(b *Block) error {
+	if len(b.transactions) > p.MaxBlockTransactions {
+		return fmt.Errorf("%w: %d transactions, limit %d", ErrTooManyTransactions, len(b.transactions), p.MaxBlockTransactions)
+	}
+	if size := b.Size(); size > p.MaxBlockSize {
+		return fmt.Errorf("%w: %d bytes, limit %d", ErrBlockTooLarge, size, p.MaxBlockSize)
+	}
+	return nil
+}
+
+// checkBits rejects targets that are malformed or easier than the limit.
+func (p *ChainParams) checkBits(bits uint32) error {
+	target := CompactToTarget(bits)
+	if target.Sign() <= 0 {
+		return ErrInvalidBits
+	}
+	if target.Cmp(CompactToTarget(p.PowLimitBits)) > 0 {
+		return ErrInvalidBits
+	}
+	return nil
+}
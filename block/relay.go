@@ -0,0 +1,254 @@
+package block
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"goblockchain/utils"
+	"log"
+	"net/http"
+	"sync"
+)
+
+const (
+	// MAX_SEEN_BLOCKS and MAX_SEEN_TRANSACTIONS bound how many relayed
+	// block hashes and transaction IDs are remembered.
+	MAX_SEEN_BLOCKS       = 1000
+	MAX_SEEN_TRANSACTIONS = 10000
+	// TX_RELAY_TTL is how many hops a new transaction travels at most.
+	TX_RELAY_TTL = 8
+)
+
+// seenSet remembers the most recent ids it was given, so an announcement
+// that comes back around the network is dropped instead of relayed again.
+// It has its own lock because it is consulted before the chain lock is
+// taken.
+type seenSet struct {
+	mux   sync.Mutex
+	ids   map[[32]byte]bool
+	order [][32]byte
+	max   int
+}
+
+func newSeenSet(max int) *seenSet {
+	return &seenSet{ids: make(map[[32]byte]bool), max: max}
+}
+
+func (s *seenSet) has(id [32]byte) bool {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	return s.ids[id]
+}
+
+func (s *seenSet) remove(id [32]byte) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	if !s.ids[id] {
+		return
+	}
+	delete(s.ids, id)
+	for i, o := range s.order {
+		if o == id {
+			s.order = append(s.order[:i], s.order[i+1:]...)
+			break
+		}
+	}
+}
+
+// add reports whether id is new, remembering it either way.
+func (s *seenSet) add(id [32]byte) bool {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	if s.ids[id] {
+		return false
+	}
+	if len(s.order) >= s.max {
+		delete(s.ids, s.order[0])
+		s.order = s.order[1:]
+	}
+	s.ids[id] = true
+	s.order = append(s.order, id)
+	return true
+}
+
+// InventoryRequest announces blocks by hash. Port is where the sender
+// serves them; the receiver pairs it with the address the request came
+// from.
+type InventoryRequest struct {
+	Hashes [][32]byte
+	Port   uint16
+}
+
+func (ir *InventoryRequest) MarshalJSON() ([]byte, error) {
+	hashes := make([]string, len(ir.Hashes))
+	for i, h := range ir.Hashes {
+		hashes[i] = hex.EncodeToString(h[:])
+	}
+	return json.Marshal(struct {
+		Hashes []string `json:"hashes"`
+		Port   uint16   `json:"port"`
+	}{
+		Hashes: hashes,
+		Port:   ir.Port,
+	})
+}
+
+func (ir *InventoryRequest) UnmarshalJSON(data []byte) error {
+	var hashes []string
+	v := &struct {
+		Hashes *[]string `json:"hashes"`
+		Port   *uint16   `json:"port"`
+	}{
+		Hashes: &hashes,
+		Port:   &ir.Port,
+	}
+	if err := json.Unmarshal(data, v); err != nil {
+		return err
+	}
+	ir.Hashes = make([][32]byte, len(hashes))
+	for i, h := range hashes {
+		var err error
+		if ir.Hashes[i], err = DecodeHash(h); err != nil {
+			return fmt.Errorf("hashes: %w", err)
+		}
+	}
+	return nil
+}
+
+// announceBlock sends the hash of a block this node accepted to every
+// neighbor but except, the peer it came from.
+func (bc *BlockChain) announceBlock(hash [32]byte, except string) {
+	m, _ := json.Marshal(&InventoryRequest{Hashes: [][32]byte{hash}, Port: bc.port})
+	for _, n := range bc.Neighbors() {
+		if n == except {
+			continue
+		}
+		resp, err := peerClient.Post(fmt.Sprintf("http://%s/blocks/inv", n), "application/json", bytes.NewReader(m))
+		if err != nil {
+			log.Printf("action=announce_block, peer=%s, status=fail, reason=%v", n, err)
+			continue
+		}
+		resp.Body.Close()
+	}
+}
+
+// ReceiveInventory fetches the announced blocks this node has not seen
+// from peer, in the background so the announcer is not held up. A hash
+// is only remembered once its block was accepted, so an announcement
+// that cannot be served does not stop another peer's from being fetched.
+func (bc *BlockChain) ReceiveInventory(hashes [][32]byte, peer string) {
+	for _, hash := range hashes {
+		if bc.seenBlocks.has(hash) {
+			continue
+		}
+		if _, err := bc.store.BlockByHash(hash); err == nil {
+			bc.seenBlocks.add(hash)
+			continue
+		}
+		if !bc.fetchingBlocks.add(hash) {
+			continue
+		}
+		go bc.fetchAnnounced(hash, peer)
+	}
+}
+
+func (bc *BlockChain) fetchAnnounced(hash [32]byte, peer string) {
+	defer bc.fetchingBlocks.remove(hash)
+	var br BlocksResponse
+	if err := getJSON(fmt.Sprintf("http://%s/blocks?hash=%x", peer, hash), &br); err != nil {
+		log.Printf("action=fetch_block, hash=%x, peer=%s, status=fail, reason=%v", hash, peer, err)
+		return
+	}
+	if len(br.Blocks) != 1 || br.Blocks[0].Hash() != hash {
+		log.Printf("action=fetch_block, hash=%x, peer=%s, status=fail, reason=unexpected block", hash, peer)
+		return
+	}
+	err := bc.ReceiveBlock(br.Blocks[0], peer)
+	if err != nil && !errors.Is(err, ErrOrphanBlock) && !errors.Is(err, ErrKnownBlock) && !errors.Is(err, ErrSideBranch) {
+		log.Printf("action=fetch_block, hash=%x, peer=%s, status=rejected, reason=%v", hash, peer, err)
+	}
+}
+
+// ReceiveBlock accepts a block announced or pushed by peer. The blocks it
+// connects are announced onwards by connectOrphans.
+func (bc *BlockChain) ReceiveBlock(b *Block, peer string) error {
+	err := bc.AcceptBlock(b, peer)
+	if errors.Is(err, ErrKnownBlock) {
+		bc.seenBlocks.add(b.Hash())
+	}
+	return err
+}
+
+// relayBlock announces a block this node connected to every neighbor but
+// except, unless it already was. The caller may hold bc.mux.
+func (bc *BlockChain) relayBlock(hash [32]byte, except string) {
+	if bc.seenBlocks.add(hash) {
+		go bc.announceBlock(hash, except)
+	}
+}
+
+// ReceiveTransaction admits t to the pool and gossips it on to every
+// neighbor but peer, which sent it and is empty for a transaction submitted
+// here. ttl is how many hops t may still travel, counting the one to this
+// node. A transaction is relayed only the first time it is admitted, so
+// copies arriving over other paths stop here.
+func (bc *BlockChain) ReceiveTransaction(t *Transaction, ttl int, peer string) error {
+	id := t.ID()
+	if bc.seenTransactions.has(id) {
+		return ErrDuplicateTransaction
+	}
+	bc.mux.Lock()
+	err := bc.addToPool(t)
+	if err == nil {
+		bc.poolChanged()
+	}
+	bc.mux.Unlock()
+	if err != nil {
+		return err
+	}
+	if bc.seenTransactions.add(id) && ttl > 1 {
+		go bc.relayTransaction(t, ttl-1, peer)
+	}
+	return nil
+}
+
+// relayTransaction sends t to the neighbors but except with ttl hops left.
+func (bc *BlockChain) relayTransaction(t *Transaction, ttl int, except string) {
+	publicKeyStr := utils.PublicKeyString(t.senderPublicKey)
+	feeStr := bc.FormatAmount(t.fee)
+	signatureStr := t.signature.String()
+	bt := &TransactionRequest{
+		SenderBlockchainAddress: &t.senderBlockchainAddress,
+		SenderPublicKey:         &publicKeyStr,
+		Fee:                     &feeStr,
+		Nonce:                   &t.nonce,
+		Signature:               &signatureStr,
+	}
+	if t.outputs != nil {
+		bt.Inputs = t.inputs
+		bt.Outputs = make([]OutputRequest, len(t.outputs))
+		for i, o := range t.outputs {
+			bt.Outputs[i] = OutputRequest{Address: o.Address, Value: bc.FormatAmount(o.Value)}
+		}
+	} else {
+		valueStr := bc.FormatAmount(t.value)
+		bt.RecipientBlockchainAddress = &t.recipientBlockchainAddress
+		bt.Value = &valueStr
+	}
+	m, _ := json.Marshal(bt)
+	for _, n := range bc.Neighbors() {
+		if n == except {
+			continue
+		}
+		endpoint := fmt.Sprintf("http://%s/transactions?ttl=%d&port=%d", n, ttl, bc.port)
+		req, _ := http.NewRequest("PUT", endpoint, bytes.NewReader(m))
+		resp, err := peerClient.Do(req)
+		if err != nil {
+			log.Printf("action=relay_transaction, peer=%s, status=fail, reason=%v", n, err)
+			continue
+		}
+		resp.Body.Close()
+	}
+}
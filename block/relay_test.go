@@ -0,0 +1,154 @@
+package block
+
+import (
+	"encoding/json"
+	"goblockchain/wallet"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func newTestChain(t *testing.T, params *ChainParams) *BlockChain {
+	t.Helper()
+	bc, err := NewBlockchain("1BvBMSEYstWetqTFn5Au4m4GFg7xJaNVN2", 5001, NewMemoryStore(), params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return bc
+}
+
+func mineBlocks(t *testing.T, bc *BlockChain, n int) []*Block {
+	t.Helper()
+	for i := 0; i < n; i++ {
+		if !bc.Mining() {
+			t.Fatal("mining failed")
+		}
+	}
+	return bc.Chain()[len(bc.Chain())-n:]
+}
+
+// blockServer serves blocks like GET /blocks?hash= and records the
+// inventories posted to it.
+func blockServer(t *testing.T, blocks []*Block) (*httptest.Server, func() [][32]byte) {
+	var mux sync.Mutex
+	announced := make([][32]byte, 0)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		switch req.URL.Path {
+		case "/blocks":
+			for _, b := range blocks {
+				if h := b.Hash(); req.URL.Query().Get("hash") == encodeLocator([][32]byte{h}) {
+					json.NewEncoder(w).Encode(&BlocksResponse{Blocks: []*Block{b}})
+					return
+				}
+			}
+			w.WriteHeader(http.StatusNotFound)
+		case "/blocks/inv":
+			var inv InventoryRequest
+			json.NewDecoder(req.Body).Decode(&inv)
+			mux.Lock()
+			announced = append(announced, inv.Hashes...)
+			mux.Unlock()
+		}
+	}))
+	t.Cleanup(srv.Close)
+	return srv, func() [][32]byte {
+		mux.Lock()
+		defer mux.Unlock()
+		return append([][32]byte(nil), announced...)
+	}
+}
+
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	for deadline := time.Now().Add(5 * time.Second); !cond(); {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestReceiveInventoryRetriesAfterFailedFetch(t *testing.T) {
+	params := DefaultChainParams()
+	miner := newTestChain(t, params)
+	b := mineBlocks(t, miner, 1)[0]
+	srv, _ := blockServer(t, []*Block{b})
+
+	bc := newTestChain(t, params)
+	bc.ReceiveInventory([][32]byte{b.Hash()}, "127.0.0.1:1")
+	waitFor(t, "failed fetch", func() bool { return !bc.fetchingBlocks.has(b.Hash()) })
+	if bc.seenBlocks.has(b.Hash()) {
+		t.Fatal("block that could not be fetched was marked seen")
+	}
+
+	bc.ReceiveInventory([][32]byte{b.Hash()}, strings.TrimPrefix(srv.URL, "http://"))
+	waitFor(t, "block from honest peer", func() bool { return bc.seenBlocks.has(b.Hash()) })
+	if bc.LastBlock().Hash() != b.Hash() {
+		t.Fatal("announced block was not connected")
+	}
+}
+
+func TestConnectedOrphansAreAnnounced(t *testing.T) {
+	params := DefaultChainParams()
+	miner := newTestChain(t, params)
+	blocks := mineBlocks(t, miner, 2)
+	srv, announced := blockServer(t, nil)
+
+	bc := newTestChain(t, params)
+	bc.setNeighbors([]string{strings.TrimPrefix(srv.URL, "http://")})
+	if err := bc.ReceiveBlock(blocks[1], ""); err == nil {
+		t.Fatal("orphan was connected")
+	}
+	if err := bc.ReceiveBlock(blocks[0], ""); err != nil {
+		t.Fatal(err)
+	}
+	if bc.LastBlock().Hash() != blocks[1].Hash() {
+		t.Fatal("orphan was not connected after its parent")
+	}
+	waitFor(t, "announcements", func() bool { return len(announced()) == 2 })
+
+	bc.ReceiveBlock(blocks[1], "")
+	time.Sleep(50 * time.Millisecond)
+	if n := len(announced()); n != 2 {
+		t.Fatalf("announced %d times, want 2", n)
+	}
+}
+
+func TestTransactionRelayCountsDownTTL(t *testing.T) {
+	a, b := wallet.NewWallet(), wallet.NewWallet()
+	var mux sync.Mutex
+	ttls := make([]string, 0)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		mux.Lock()
+		ttls = append(ttls, req.URL.Query().Get("ttl"))
+		mux.Unlock()
+	}))
+	t.Cleanup(srv.Close)
+	relayed := func() []string {
+		mux.Lock()
+		defer mux.Unlock()
+		return append([]string(nil), ttls...)
+	}
+
+	bc := newTestChain(t, fundedParams(a))
+	bc.setNeighbors([]string{strings.TrimPrefix(srv.URL, "http://")})
+	if err := bc.ReceiveTransaction(signedTransfer(a, b, 1000, 100000, 0), 3, ""); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, "relay", func() bool { return len(relayed()) == 1 })
+	if got := relayed()[0]; got != "2" {
+		t.Fatalf("relayed with ttl %s, want 2", got)
+	}
+	for nonce, ttl := range []int{1, 0} {
+		if err := bc.ReceiveTransaction(signedTransfer(a, b, 1000, 100000, uint64(nonce+1)), ttl, ""); err != nil {
+			t.Fatal(err)
+		}
+	}
+	time.Sleep(50 * time.Millisecond)
+	if n := len(relayed()); n != 1 {
+		t.Fatalf("relayed %d times, want only the transaction with hops left", n)
+	}
+}
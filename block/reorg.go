@@ -0,0 +1,134 @@
+package block
+
+import (
+	"fmt"
+	"log"
+	"math/big"
+)
+
+// BlockWork is the expected number of hashes needed to meet the target
+// encoded by bits, 2^256 / (target + 1).
+func BlockWork(bits uint32) *big.Int {
+	target := CompactToTarget(bits)
+	if target.Sign() <= 0 {
+		return new(big.Int)
+	}
+	work := new(big.Int).Lsh(big.NewInt(1), 256)
+	return work.Div(work, target.Add(target, big.NewInt(1)))
+}
+
+// ChainWork is the total work of every block in chain.
+func ChainWork(chain []*Block) *big.Int {
+	return HeadersWork(headersOf(chain))
+}
+
+func HeadersWork(headers []*BlockHeader) *big.Int {
+	work := new(big.Int)
+	for _, h := range headers {
+		work.Add(work, BlockWork(h.bits))
+	}
+	return work
+}
+
+// ReorgEvent describes a switch of the local chain to a competing branch.
+// Depth is the number of local blocks that were rolled back.
+type ReorgEvent struct {
+	AncestorHeight int
+	Depth          int
+	OldTip         [32]byte
+	NewTip         [32]byte
+	Dropped        []*Transaction
+}
+
+// OnReorg registers fn to be called after every reorganization.
+func (bc *BlockChain) OnReorg(fn func(*ReorgEvent)) {
+	bc.reorgHandlers = append(bc.reorgHandlers, fn)
+}
+
+// commonAncestor returns the height of the last block chain shares with the
+// local chain, or -1 if not even the genesis blocks match.
+func (bc *BlockChain) commonAncestor(chain []*Block) int {
+	height := -1
+	for i := 0; i < len(chain) && i < len(bc.chain); i++ {
+		if chain[i].Hash() != bc.chain[i].Hash() {
+			break
+		}
+		height = i
+	}
+	return height
+}
+
+// reorganize switches to the already validated chain. The local blocks
+// above the common ancestor are rolled back in the store and the state
+// index and the new branch is connected; should that fail, the old branch
+// is connected again. Transactions that only the old branch confirmed are
+// offered back to the pool along with what was already pending.
+func (bc *BlockChain) reorganize(chain []*Block) error {
+	ancestor := bc.commonAncestor(chain)
+	old := bc.chain
+	if err := bc.switchBranch(ancestor, chain[ancestor+1:]); err != nil {
+		if restoreErr := bc.switchBranch(ancestor, old[ancestor+1:]); restoreErr != nil {
+			log.Printf("ERROR: reorg: restoring the old branch: %v", restoreErr)
+			return fmt.Errorf("%w; restoring the old branch: %v", err, restoreErr)
+		}
+		return err
+	}
+	bc.cancelMining()
+
+	confirmed := make(map[[32]byte]bool)
+	for _, b := range chain[ancestor+1:] {
+		for _, t := range b.transactions {
+			confirmed[t.ID()] = true
+		}
+	}
+	dropped := make([]*Transaction, 0)
+	for _, b := range old[ancestor+1:] {
+		for _, t := range b.transactions {
+			if !t.coinbase && !confirmed[t.ID()] {
+				dropped = append(dropped, t)
+			}
+		}
+	}
+	bc.revalidatePool(dropped)
+
+	event := &ReorgEvent{
+		AncestorHeight: ancestor,
+		Depth:          len(old) - (ancestor + 1),
+		OldTip:         old[len(old)-1].Hash(),
+		NewTip:         chain[len(chain)-1].Hash(),
+		Dropped:        dropped,
+	}
+	log.Printf("action=reorg, depth=%d, ancestor=%d, old_tip=%x, new_tip=%x, new_height=%d",
+		event.Depth, event.AncestorHeight, event.OldTip, event.NewTip, len(chain)-1)
+	for _, fn := range bc.reorgHandlers {
+		fn(event)
+	}
+	return nil
+}
+
+// switchBranch rolls the chain back to height ancestor and connects branch
+// on top of it. On failure the chain ends at the last block connected.
+func (bc *BlockChain) switchBranch(ancestor int, branch []*Block) error {
+	if err := bc.store.Truncate(ancestor + 1); err != nil {
+		return err
+	}
+	for height := len(bc.chain) - 1; height > ancestor; height-- {
+		bc.state.disconnectBlock(bc.undo[height])
+	}
+	bc.chain = bc.chain[: ancestor+1 : ancestor+1]
+	bc.undo = bc.undo[: ancestor+1 : ancestor+1]
+	for i, b := range branch {
+		height := ancestor + 1 + i
+		undo, err := bc.state.connectBlock(height, b)
+		if err != nil {
+			return err
+		}
+		if err := bc.store.Append(b); err != nil {
+			bc.state.disconnectBlock(undo)
+			return err
+		}
+		bc.chain = append(bc.chain, b)
+		bc.undo = append(bc.undo, undo)
+	}
+	return nil
+}
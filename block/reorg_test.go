@@ -0,0 +1,97 @@
+package block
+
+import (
+	"errors"
+	"goblockchain/wallet"
+	"testing"
+)
+
+// failingStore refuses to append the block with hash failOn.
+type failingStore struct {
+	Store
+	failOn [32]byte
+}
+
+var errStoreFailed = errors.New("store failed")
+
+func (s *failingStore) Append(b *Block) error {
+	if b.Hash() == s.failOn {
+		return errStoreFailed
+	}
+	return s.Store.Append(b)
+}
+
+func TestReorgReportsDroppedTransactions(t *testing.T) {
+	a, b := wallet.NewWallet(), wallet.NewWallet()
+	params := fundedParams(a)
+	shared, onlyLocal := signedTransfer(a, b, 1000, 100000, 0), signedTransfer(a, b, 1000, 100000, 1)
+
+	bc := newTestChain(t, params)
+	for _, tx := range []*Transaction{shared, onlyLocal} {
+		if err := bc.ReceiveTransaction(tx, 1, ""); err != nil {
+			t.Fatal(err)
+		}
+	}
+	local := mineBlocks(t, bc, 1)[0]
+	other := newTestChain(t, params)
+	if err := other.ReceiveTransaction(shared, 1, ""); err != nil {
+		t.Fatal(err)
+	}
+	branch := mineBlocks(t, other, 2)
+
+	events := make([]*ReorgEvent, 0)
+	bc.OnReorg(func(e *ReorgEvent) { events = append(events, e) })
+	if err := bc.ReceiveBlock(branch[0], ""); !errors.Is(err, ErrSideBranch) {
+		t.Fatalf("branch with equal work: %v, want %v", err, ErrSideBranch)
+	}
+	if err := bc.ReceiveBlock(branch[1], ""); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(events) != 1 {
+		t.Fatalf("%d reorg events, want 1", len(events))
+	}
+	e := events[0]
+	if e.AncestorHeight != 0 || e.Depth != 1 || e.OldTip != local.Hash() || e.NewTip != branch[1].Hash() {
+		t.Fatalf("event %+v does not describe the switch from %x to %x", e, local.Hash(), branch[1].Hash())
+	}
+	if len(e.Dropped) != 1 || e.Dropped[0].ID() != onlyLocal.ID() {
+		t.Fatalf("dropped %d transactions, want only the one the new branch lacks", len(e.Dropped))
+	}
+	if pool := bc.TransactionPool(); len(pool) != 1 || pool[0].ID() != onlyLocal.ID() {
+		t.Fatalf("pool holds %d transactions, want the dropped one", len(pool))
+	}
+}
+
+func TestFailedReorgRestoresOldBranch(t *testing.T) {
+	params := DefaultChainParams()
+	other := newTestChain(t, params)
+	branch := mineBlocks(t, other, 2)
+
+	store := &failingStore{Store: NewMemoryStore(), failOn: branch[1].Hash()}
+	bc, err := NewBlockchain("1BvBMSEYstWetqTFn5Au4m4GFg7xJaNVN2", 5001, store, params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	local := mineBlocks(t, bc, 1)[0]
+	if err := bc.ReceiveBlock(branch[0], ""); !errors.Is(err, ErrSideBranch) {
+		t.Fatalf("branch with equal work: %v, want %v", err, ErrSideBranch)
+	}
+	if err := bc.ReceiveBlock(branch[1], ""); !errors.Is(err, errStoreFailed) {
+		t.Fatalf("reorg: %v, want %v", err, errStoreFailed)
+	}
+
+	if tip := bc.Tip(); tip.Hash != local.Hash() {
+		t.Fatalf("tip %x, want the old tip %x", tip.Hash, local.Hash())
+	}
+	if stored, err := store.Tip(); err != nil || stored.Hash() != local.Hash() {
+		t.Fatalf("stored tip does not match the old tip: %v", err)
+	}
+	sc, err := bc.CheckState()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !sc.Consistent() {
+		t.Fatalf("state after failed reorg: %v", sc.Mismatches)
+	}
+}
@@ -0,0 +1,519 @@
+package block
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"goblockchain/utils"
+	"goblockchain/wallet"
+	"sort"
+	"sync"
+)
+
+// chainState is the ledger produced by replaying blocks in order: the
+// balance and the next expected nonce of every address. The chain keeps
+// one for its tip and updates it as blocks are connected and rolled back,
+// so lookups never have to scan blocks.
+type chainState struct {
+	mux      sync.RWMutex
+	params   *ChainParams
+	balances map[string]utils.Amount
+	nonces   map[string]uint64
+	// issued is the subsidy minted so far; fees only change hands.
+	issued utils.Amount
+	// circulating is the coin held in balances, issued minus the fees no
+	// coinbase claimed.
+	circulating utils.Amount
+	// utxos holds the unspent outputs on a UTXO ledger, where balances
+	// are the sums of the outputs each address owns.
+	utxos *utxoSet
+	// journal records what the block being applied changes, if set.
+	journal *stateUndo
+}
+
+// stateUndo holds the values a block overwrote, which is all it takes to
+// roll the block back.
+type stateUndo struct {
+	balances    map[string]utils.Amount
+	nonces      map[string]uint64
+	spent       map[utils.OutPoint]utils.TxOutput
+	created     map[utils.OutPoint]bool
+	issued      utils.Amount
+	circulating utils.Amount
+}
+
+func newChainState(params *ChainParams) *chainState {
+	return &chainState{
+		params:   params,
+		balances: make(map[string]utils.Amount),
+		nonces:   make(map[string]uint64),
+		utxos:    newUTXOSet(),
+	}
+}
+
+// clone copies s, so a branch can be tried out on top of it.
+func (s *chainState) clone() *chainState {
+	s.mux.RLock()
+	defer s.mux.RUnlock()
+	c := newChainState(s.params)
+	for address, balance := range s.balances {
+		c.balances[address] = balance
+	}
+	for address, nonce := range s.nonces {
+		c.nonces[address] = nonce
+	}
+	c.issued, c.circulating = s.issued, s.circulating
+	c.utxos = s.utxos.clone()
+	return c
+}
+
+func (s *chainState) balance(address string) utils.Amount {
+	s.mux.RLock()
+	defer s.mux.RUnlock()
+	return s.balances[address]
+}
+
+func (s *chainState) nonce(address string) uint64 {
+	s.mux.RLock()
+	defer s.mux.RUnlock()
+	return s.nonces[address]
+}
+
+func (s *chainState) output(o utils.OutPoint) (utils.TxOutput, bool) {
+	s.mux.RLock()
+	defer s.mux.RUnlock()
+	return s.utxos.get(o)
+}
+
+func (s *chainState) unspent(address string) []*utils.UnspentOutput {
+	s.mux.RLock()
+	defer s.mux.RUnlock()
+	return s.utxos.unspent(address)
+}
+
+func (s *chainState) supply() (issued utils.Amount, circulating utils.Amount) {
+	s.mux.RLock()
+	defer s.mux.RUnlock()
+	return s.issued, s.circulating
+}
+
+func (s *chainState) root() [32]byte {
+	s.mux.RLock()
+	defer s.mux.RUnlock()
+	return s.hash()
+}
+
+// connectBlock applies the block at height and checks the state root its
+// header commits to. On error the state is left as it was; otherwise the
+// returned undo rolls the block back.
+func (s *chainState) connectBlock(height int, b *Block) (*stateUndo, error) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	undo, err := s.journalBlock(height, b)
+	if err != nil {
+		return nil, err
+	}
+	if root := s.hash(); root != b.header.stateRoot {
+		s.undo(undo)
+		return nil, fmt.Errorf("block %d: %w: header %x, computed %x", height, ErrStateRootMismatch, b.header.stateRoot, root)
+	}
+	return undo, nil
+}
+
+// disconnectBlock rolls back the block undo was recorded for. Blocks have
+// to be disconnected from the tip down.
+func (s *chainState) disconnectBlock(undo *stateUndo) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	s.undo(undo)
+}
+
+// rootAfter is the state root the block at height would commit to. The
+// state itself is left unchanged.
+func (s *chainState) rootAfter(height int, b *Block) ([32]byte, error) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	undo, err := s.journalBlock(height, b)
+	if err != nil {
+		return [32]byte{}, err
+	}
+	root := s.hash()
+	s.undo(undo)
+	return root, nil
+}
+
+func (s *chainState) journalBlock(height int, b *Block) (*stateUndo, error) {
+	s.journal = &stateUndo{
+		balances:    make(map[string]utils.Amount),
+		nonces:      make(map[string]uint64),
+		spent:       make(map[utils.OutPoint]utils.TxOutput),
+		created:     make(map[utils.OutPoint]bool),
+		issued:      s.issued,
+		circulating: s.circulating,
+	}
+	undo := s.journal
+	err := s.applyBlock(height, b)
+	s.journal = nil
+	if err != nil {
+		s.undo(undo)
+		return nil, err
+	}
+	return undo, nil
+}
+
+func (s *chainState) undo(u *stateUndo) {
+	for address, balance := range u.balances {
+		s.writeBalance(address, balance)
+	}
+	for address, nonce := range u.nonces {
+		s.writeNonce(address, nonce)
+	}
+	for o := range u.created {
+		s.utxos.remove(o)
+	}
+	for o, out := range u.spent {
+		s.utxos.add(o, out)
+	}
+	s.issued = u.issued
+	s.circulating = u.circulating
+}
+
+func (s *chainState) setBalance(address string, balance utils.Amount) {
+	if s.journal != nil {
+		if _, ok := s.journal.balances[address]; !ok {
+			s.journal.balances[address] = s.balances[address]
+		}
+	}
+	s.writeBalance(address, balance)
+}
+
+// writeBalance drops zero balances so that equal states hold equal maps.
+func (s *chainState) writeBalance(address string, balance utils.Amount) {
+	if balance == 0 {
+		delete(s.balances, address)
+		return
+	}
+	s.balances[address] = balance
+}
+
+func (s *chainState) setNonce(address string, nonce uint64) {
+	if s.journal != nil {
+		if _, ok := s.journal.nonces[address]; !ok {
+			s.journal.nonces[address] = s.nonces[address]
+		}
+	}
+	s.writeNonce(address, nonce)
+}
+
+func (s *chainState) writeNonce(address string, nonce uint64) {
+	if nonce == 0 {
+		delete(s.nonces, address)
+		return
+	}
+	s.nonces[address] = nonce
+}
+
+// hash is the state root: a digest of every account and unspent output in
+// sorted order, followed by the supply counters.
+func (s *chainState) hash() [32]byte {
+	addresses := make([]string, 0, len(s.balances)+len(s.nonces))
+	for address := range s.balances {
+		addresses = append(addresses, address)
+	}
+	for address := range s.nonces {
+		if _, ok := s.balances[address]; !ok {
+			addresses = append(addresses, address)
+		}
+	}
+	sort.Strings(addresses)
+	e := utils.NewEncoder()
+	e.PutUint32(uint32(len(addresses)))
+	for _, address := range addresses {
+		e.PutString(address)
+		e.PutAmount(s.balances[address])
+		e.PutUint64(s.nonces[address])
+	}
+	outPoints := s.utxos.outPoints()
+	e.PutUint32(uint32(len(outPoints)))
+	for _, o := range outPoints {
+		e.PutOutPoint(o)
+		e.PutTxOutput(s.utxos.outputs[o])
+	}
+	e.PutAmount(s.issued)
+	e.PutAmount(s.circulating)
+	return sha256.Sum256(e.Bytes())
+}
+
+// diff lists where s and other disagree, in address and out point order
+// and at most max entries.
+func (s *chainState) diff(other *chainState, max int) []string {
+	diffs := make([]string, 0)
+	report := func(format string, args ...interface{}) {
+		if len(diffs) < max {
+			diffs = append(diffs, fmt.Sprintf(format, args...))
+		}
+	}
+	if s.issued != other.issued {
+		report("issued: %d != %d", s.issued, other.issued)
+	}
+	if s.circulating != other.circulating {
+		report("circulating: %d != %d", s.circulating, other.circulating)
+	}
+	seen := make(map[string]bool)
+	addresses := make([]string, 0)
+	for _, st := range []*chainState{s, other} {
+		for address := range st.balances {
+			if !seen[address] {
+				seen[address] = true
+				addresses = append(addresses, address)
+			}
+		}
+		for address := range st.nonces {
+			if !seen[address] {
+				seen[address] = true
+				addresses = append(addresses, address)
+			}
+		}
+	}
+	sort.Strings(addresses)
+	for _, address := range addresses {
+		if s.balances[address] != other.balances[address] {
+			report("balance of %s: %d != %d", address, s.balances[address], other.balances[address])
+		}
+		if s.nonces[address] != other.nonces[address] {
+			report("nonce of %s: %d != %d", address, s.nonces[address], other.nonces[address])
+		}
+	}
+	outPoints := s.utxos.outPoints()
+	for _, o := range other.utxos.outPoints() {
+		if _, ok := s.utxos.get(o); !ok {
+			outPoints = append(outPoints, o)
+		}
+	}
+	sort.Slice(outPoints, func(i, j int) bool { return compareOutPoints(outPoints[i], outPoints[j]) })
+	for _, o := range outPoints {
+		mine, ok := s.utxos.get(o)
+		theirs, theirsOK := other.utxos.get(o)
+		if ok != theirsOK || mine != theirs {
+			report("output %s: %v != %v", o, mine, theirs)
+		}
+	}
+	return diffs
+}
+
+// applyBlock replays the transactions of the block at height. A coinbase
+// may only come first; the transactions after it are applied in order and
+// their fees are what the coinbase may claim on top of the subsidy.
+func (s *chainState) applyBlock(height int, b *Block) error {
+	if height == 0 {
+		return s.applyGenesis(b)
+	}
+	transactions := b.transactions
+	var coinbase *Transaction
+	if len(transactions) > 0 && transactions[0].coinbase {
+		coinbase, transactions = transactions[0], transactions[1:]
+	}
+	var fees utils.Amount
+	for _, t := range transactions {
+		if t.coinbase || t.senderBlockchainAddress == MINING_SENDER {
+			return fmt.Errorf("block %d: %w: only the first transaction may be a coinbase", height, ErrInvalidCoinbase)
+		}
+		if err := s.applyTransaction(t); err != nil {
+			return fmt.Errorf("block %d: %w", height, err)
+		}
+		var err error
+		if fees, err = utils.AddAmount(fees, t.fee); err != nil {
+			return fmt.Errorf("block %d: %w", height, err)
+		}
+	}
+	var err error
+	if s.circulating, err = utils.SubAmount(s.circulating, fees); err != nil {
+		return fmt.Errorf("block %d: %w", height, err)
+	}
+	if coinbase != nil {
+		if err := s.applyCoinbase(height, coinbase, fees); err != nil {
+			return fmt.Errorf("block %d: %w", height, err)
+		}
+	}
+	return nil
+}
+
+// checkSender verifies that t is signed by the key its sender address was
+// derived from.
+func checkSender(t *Transaction) error {
+	if t.senderPublicKey == nil || t.signature == nil || !t.VerifySignature() {
+		return ErrInvalidSignature
+	}
+	if wallet.AddressFromPublicKey(t.senderPublicKey) != t.senderBlockchainAddress {
+		return fmt.Errorf("%w: %s", ErrSenderMismatch, t.senderBlockchainAddress)
+	}
+	return nil
+}
+
+// applyTransaction moves value and fee out of the sender's balance, or on
+// a UTXO ledger spends the inputs of t and adds its outputs. An account
+// transaction must carry the sender's next nonce and may not spend more
+// than the sender holds at that point.
+func (s *chainState) applyTransaction(t *Transaction) error {
+	if err := checkSender(t); err != nil {
+		return err
+	}
+	if s.params.Ledger == LEDGER_UTXO {
+		if err := checkUTXOTransaction(t, s.utxos.get); err != nil {
+			return err
+		}
+		return s.connectOutputs(t)
+	}
+	if len(t.inputs) > 0 || len(t.outputs) > 0 {
+		return fmt.Errorf("%w: account transactions carry no inputs or outputs", ErrWrongLedger)
+	}
+	if err := wallet.ValidateAddress(t.recipientBlockchainAddress); err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidRecipient, err)
+	}
+	if t.nonce != s.nonces[t.senderBlockchainAddress] {
+		return fmt.Errorf("%w: %s sent nonce %d, expected %d", ErrNonceGap,
+			t.senderBlockchainAddress, t.nonce, s.nonces[t.senderBlockchainAddress])
+	}
+	s.setNonce(t.senderBlockchainAddress, t.nonce+1)
+	if t.value <= 0 {
+		return ErrInvalidValue
+	}
+	cost, err := t.Cost()
+	if err != nil {
+		return err
+	}
+	if err := s.debit(t.senderBlockchainAddress, cost); err != nil {
+		return err
+	}
+	return s.credit(t.recipientBlockchainAddress, t.value)
+}
+
+// applyGenesis pays out the allocations of the genesis block, which
+// consists of nothing but coinbases. They count as issued like any other
+// minted coin.
+func (s *chainState) applyGenesis(b *Block) error {
+	for _, t := range b.transactions {
+		if !t.coinbase {
+			return fmt.Errorf("block 0: %w: the genesis block only holds allocations", ErrInvalidCoinbase)
+		}
+		if err := checkCoinbase(0, t); err != nil {
+			return fmt.Errorf("block 0: %w", err)
+		}
+		var err error
+		if s.issued, err = utils.AddAmount(s.issued, t.value); err != nil {
+			return fmt.Errorf("block 0: %w", err)
+		}
+		if s.issued > s.params.MaxSupply {
+			return fmt.Errorf("block 0: %w: allocations exceed the maximum supply", ErrInvalidCoinbase)
+		}
+		s.circulating = s.issued
+		if s.params.Ledger == LEDGER_UTXO {
+			err = s.connectOutputs(t)
+		} else {
+			err = s.credit(t.recipientBlockchainAddress, t.value)
+		}
+		if err != nil {
+			return fmt.Errorf("block 0: %w", err)
+		}
+	}
+	return nil
+}
+
+func checkCoinbase(height int, t *Transaction) error {
+	if t.senderBlockchainAddress != MINING_SENDER || t.fee != 0 || t.nonce != uint64(height) ||
+		t.senderPublicKey != nil || t.signature != nil {
+		return ErrInvalidCoinbase
+	}
+	if err := wallet.ValidateAddress(t.recipientBlockchainAddress); err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidRecipient, err)
+	}
+	return nil
+}
+
+// applyCoinbase pays the miner once the rest of the block is applied, so
+// the reward cannot be spent within the block that mints it. It may claim
+// at most the subsidy of the height plus fees.
+func (s *chainState) applyCoinbase(height int, t *Transaction, fees utils.Amount) error {
+	if err := checkCoinbase(height, t); err != nil {
+		return err
+	}
+	limit, err := utils.AddAmount(s.params.Subsidy(height, s.issued), fees)
+	if err != nil {
+		return err
+	}
+	if t.value > limit {
+		return fmt.Errorf("%w: claims %d, limit %d", ErrInvalidCoinbase, t.value, limit)
+	}
+	if t.value > fees {
+		s.issued += t.value - fees
+	}
+	if s.circulating, err = utils.AddAmount(s.circulating, t.value); err != nil {
+		return err
+	}
+	if s.params.Ledger == LEDGER_UTXO {
+		return s.connectOutputs(t)
+	}
+	return s.credit(t.recipientBlockchainAddress, t.value)
+}
+
+// connectOutputs spends the inputs of t and adds its outputs, keeping the
+// balances of their owners in step.
+func (s *chainState) connectOutputs(t *Transaction) error {
+	for _, o := range t.inputs {
+		out, ok := s.utxos.get(o)
+		if !ok {
+			return fmt.Errorf("%w: %s", ErrMissingInput, o)
+		}
+		if s.journal != nil {
+			if s.journal.created[o] {
+				delete(s.journal.created, o)
+			} else {
+				s.journal.spent[o] = out
+			}
+		}
+		s.utxos.remove(o)
+		if err := s.debit(out.Address, out.Value); err != nil {
+			return err
+		}
+	}
+	id := t.ID()
+	for i, out := range t.Outputs() {
+		o := utils.OutPoint{TxID: id, Index: uint32(i)}
+		if _, ok := s.utxos.get(o); ok {
+			return fmt.Errorf("%w: %s already exists", ErrDoubleSpend, o)
+		}
+		if s.journal != nil {
+			s.journal.created[o] = true
+		}
+		s.utxos.add(o, out)
+		if err := s.credit(out.Address, out.Value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *chainState) debit(address string, value utils.Amount) error {
+	balance, err := utils.SubAmount(s.balances[address], value)
+	if err != nil {
+		return fmt.Errorf("%w: %s spends %d of %d", ErrInsufficientBalance, address, value, s.balances[address])
+	}
+	s.setBalance(address, balance)
+	return nil
+}
+
+func (s *chainState) credit(address string, value utils.Amount) error {
+	balance, err := utils.AddAmount(s.balances[address], value)
+	if err != nil {
+		return fmt.Errorf("%s: %w", address, err)
+	}
+	s.setBalance(address, balance)
+	return nil
+}
+
+func compareOutPoints(a, b utils.OutPoint) bool {
+	if c := bytes.Compare(a.TxID[:], b.TxID[:]); c != 0 {
+		return c < 0
+	}
+	return a.Index < b.Index
+}
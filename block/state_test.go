@@ -0,0 +1,38 @@
+package block
+
+import (
+	"errors"
+	"goblockchain/wallet"
+	"testing"
+)
+
+func TestCoinbaseRejections(t *testing.T) {
+	a, b := wallet.NewWallet(), wallet.NewWallet()
+	params := fundedParams(a)
+	bc := newTestChain(t, params)
+	miner := bc.blockhainAddress
+	transfer := signedTransfer(a, b, 1000, 100000, 0)
+	issued := bc.IssuedSupply()
+	limit := params.Subsidy(1, issued) + transfer.fee
+
+	tests := []struct {
+		name         string
+		transactions []*Transaction
+		err          error
+	}{
+		{"subsidy plus fees", []*Transaction{NewCoinbase(miner, limit, 1), transfer}, nil},
+		{"second coinbase", []*Transaction{NewCoinbase(miner, limit, 1), NewCoinbase(miner, 1, 1), transfer}, ErrInvalidCoinbase},
+		{"coinbase not first", []*Transaction{transfer, NewCoinbase(miner, limit, 1)}, ErrInvalidCoinbase},
+		{"pays more than subsidy plus fees", []*Transaction{NewCoinbase(miner, limit+1, 1), transfer}, ErrInvalidCoinbase},
+		{"wrong height", []*Transaction{NewCoinbase(miner, limit, 2), transfer}, ErrInvalidCoinbase},
+	}
+	for _, tt := range tests {
+		block := NewBlock(1, bc.LastBlock().Hash(), bc.LastBlock().header.timestamp+1, tt.transactions, params.GenesisBits)
+		if _, err := bc.state.rootAfter(1, block); !errors.Is(err, tt.err) {
+			t.Errorf("%s: %v, want %v", tt.name, err, tt.err)
+		}
+	}
+	if got := bc.IssuedSupply(); got != issued {
+		t.Fatalf("checking the blocks changed the issued supply from %d to %d", issued, got)
+	}
+}
@@ -0,0 +1,81 @@
+package block
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+const MAX_STATE_CHECK_MISMATCHES = 100
+
+// StateCheckResponse compares the state index with the state rebuilt by
+// replaying the stored blocks. HeaderRoot is the state root the tip block
+// commits to; all three roots agree on a consistent node.
+type StateCheckResponse struct {
+	Height      int
+	IndexRoot   [32]byte
+	RebuiltRoot [32]byte
+	HeaderRoot  [32]byte
+	Mismatches  []string
+}
+
+func (sc *StateCheckResponse) Consistent() bool {
+	return len(sc.Mismatches) == 0 && sc.IndexRoot == sc.RebuiltRoot && sc.IndexRoot == sc.HeaderRoot
+}
+
+// CheckState rebuilds the state by applying every block in the store from
+// genesis, with the same signature and balance checks as validation, and
+// lists every balance, nonce and output where the index, which was kept up
+// to date block by block, disagrees with it.
+func (bc *BlockChain) CheckState() (*StateCheckResponse, error) {
+	bc.mux.Lock()
+	defer bc.mux.Unlock()
+	rebuilt := newChainState(bc.params)
+	mismatches := make([]string, 0)
+	height := -1
+	err := bc.store.Iterate(func(i int, b *Block) bool {
+		if err := rebuilt.applyBlock(i, b); err != nil {
+			mismatches = append(mismatches, fmt.Sprintf("rebuild stopped: %v", err))
+			return false
+		}
+		height = i
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+	if height != len(bc.chain)-1 {
+		mismatches = append(mismatches, fmt.Sprintf("store holds %d blocks, chain %d", height+1, len(bc.chain)))
+	}
+	bc.state.mux.RLock()
+	mismatches = append(mismatches, bc.state.diff(rebuilt, MAX_STATE_CHECK_MISMATCHES)...)
+	indexRoot := bc.state.hash()
+	bc.state.mux.RUnlock()
+	sc := &StateCheckResponse{
+		Height:      height,
+		IndexRoot:   indexRoot,
+		RebuiltRoot: rebuilt.hash(),
+		Mismatches:  mismatches,
+	}
+	if len(bc.chain) > 0 {
+		sc.HeaderRoot = bc.LastBlock().header.stateRoot
+	}
+	return sc, nil
+}
+
+func (sc *StateCheckResponse) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Height      int      `json:"height"`
+		Consistent  bool     `json:"consistent"`
+		IndexRoot   string   `json:"index_root"`
+		RebuiltRoot string   `json:"rebuilt_root"`
+		HeaderRoot  string   `json:"header_root"`
+		Mismatches  []string `json:"mismatches"`
+	}{
+		Height:      sc.Height,
+		Consistent:  sc.Consistent(),
+		IndexRoot:   fmt.Sprintf("%x", sc.IndexRoot),
+		RebuiltRoot: fmt.Sprintf("%x", sc.RebuiltRoot),
+		HeaderRoot:  fmt.Sprintf("%x", sc.HeaderRoot),
+		Mismatches:  sc.Mismatches,
+	})
+}
@@ -0,0 +1,30 @@
+package block
+
+import (
+	"goblockchain/wallet"
+	"testing"
+)
+
+func TestCheckStateFindsDivergentIndex(t *testing.T) {
+	a, b := wallet.NewWallet(), wallet.NewWallet()
+	bc := newTestChain(t, fundedParams(a))
+	if err := bc.ReceiveTransaction(signedTransfer(a, b, 1000, 100000, 0), 1, ""); err != nil {
+		t.Fatal(err)
+	}
+	mineBlocks(t, bc, 2)
+	sc, err := bc.CheckState()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !sc.Consistent() {
+		t.Fatalf("fresh index is inconsistent: %v", sc.Mismatches)
+	}
+
+	bc.state.balances[b.BlockChainAddress()] += 1
+	if sc, err = bc.CheckState(); err != nil {
+		t.Fatal(err)
+	}
+	if sc.Consistent() || len(sc.Mismatches) != 1 {
+		t.Fatalf("tampered index: consistent=%v, mismatches=%v", sc.Consistent(), sc.Mismatches)
+	}
+}
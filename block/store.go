@@ -0,0 +1,22 @@
+package block
+
+import "errors"
+
+var ErrBlockNotFound = errors.New("block not found")
+
+// Store persists the blocks of a chain in height order.
+type Store interface {
+	// Append adds b on top of the current tip.
+	Append(b *Block) error
+	BlockByHeight(height int) (*Block, error)
+	BlockByHash(hash [32]byte) (*Block, error)
+	// Iterate calls fn for every block from genesis up, stopping early
+	// when fn returns false.
+	Iterate(fn func(height int, b *Block) bool) error
+	Tip() (*Block, error)
+	// Len returns the number of stored blocks.
+	Len() int
+	// Truncate drops every block at or above height.
+	Truncate(height int) error
+	Close() error
+}
@@ -0,0 +1,388 @@
+package block
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	MAX_HEADERS_PER_REQUEST = 500
+	MAX_BLOCKS_PER_REQUEST  = 50
+	// PEER_TIMEOUT_SEC bounds every request to a peer, so a slow or silent
+	// one cannot hold up the node.
+	PEER_TIMEOUT_SEC = 10
+)
+
+var peerClient = &http.Client{Timeout: PEER_TIMEOUT_SEC * time.Second}
+
+var (
+	ErrNotEnoughWork  = errors.New("peer chain does not have more work")
+	ErrForeignGenesis = errors.New("peer runs a different genesis block")
+)
+
+// TipResponse summarises a node's best chain and names the network it is
+// on.
+type TipResponse struct {
+	NetworkID string
+	Genesis   [32]byte
+	Height    uint64
+	Hash      [32]byte
+	Work      *big.Int
+}
+
+func (tr *TipResponse) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		NetworkID string `json:"network_id"`
+		Genesis   string `json:"genesis"`
+		Height    uint64 `json:"height"`
+		Hash      string `json:"hash"`
+		Work      string `json:"work"`
+	}{
+		NetworkID: tr.NetworkID,
+		Genesis:   fmt.Sprintf("%x", tr.Genesis),
+		Height:    tr.Height,
+		Hash:      fmt.Sprintf("%x", tr.Hash),
+		Work:      tr.Work.Text(16),
+	})
+}
+
+func (tr *TipResponse) UnmarshalJSON(data []byte) error {
+	var genesis, hash, work string
+	v := &struct {
+		NetworkID *string `json:"network_id"`
+		Genesis   *string `json:"genesis"`
+		Height    *uint64 `json:"height"`
+		Hash      *string `json:"hash"`
+		Work      *string `json:"work"`
+	}{
+		NetworkID: &tr.NetworkID,
+		Genesis:   &genesis,
+		Height:    &tr.Height,
+		Hash:      &hash,
+		Work:      &work,
+	}
+	if err := json.Unmarshal(data, v); err != nil {
+		return err
+	}
+	var err error
+	if tr.Genesis, err = DecodeHash(genesis); err != nil {
+		return fmt.Errorf("genesis: %w", err)
+	}
+	if tr.Hash, err = DecodeHash(hash); err != nil {
+		return err
+	}
+	var ok bool
+	if tr.Work, ok = new(big.Int).SetString(work, 16); !ok {
+		return errors.New("invalid work")
+	}
+	return nil
+}
+
+type HeadersResponse struct {
+	Headers []*BlockHeader `json:"headers"`
+}
+
+type BlocksResponse struct {
+	Blocks []*Block `json:"blocks"`
+}
+
+func (bc *BlockChain) Tip() *TipResponse {
+	chain := bc.Chain()
+	last := chain[len(chain)-1]
+	return &TipResponse{
+		NetworkID: bc.params.NetworkID,
+		Genesis:   bc.genesis.Hash(),
+		Height:    last.header.height,
+		Hash:      last.Hash(),
+		Work:      ChainWork(chain),
+	}
+}
+
+// checkTipGenesis refuses a peer whose tip belongs to another network.
+func (bc *BlockChain) checkTipGenesis(tip *TipResponse) error {
+	if tip.Genesis != bc.genesis.Hash() {
+		return fmt.Errorf("%w: network %q, genesis %x", ErrForeignGenesis, tip.NetworkID, tip.Genesis)
+	}
+	return nil
+}
+
+func (bc *BlockChain) checkPeerGenesis(peer string) error {
+	var tip TipResponse
+	if err := getJSON(fmt.Sprintf("http://%s/tip", peer), &tip); err != nil {
+		return err
+	}
+	return bc.checkTipGenesis(&tip)
+}
+
+// Locator lists hashes of the local chain from the tip back to genesis, one
+// by one for the last ten blocks and then with exponentially growing gaps,
+// so a peer can find the fork point in a single round trip.
+func (bc *BlockChain) Locator() [][32]byte {
+	return locatorOf(bc.Chain())
+}
+
+func locatorOf(chain []*Block) [][32]byte {
+	locator := make([][32]byte, 0)
+	step := 1
+	for height := len(chain) - 1; height > 0; height -= step {
+		locator = append(locator, chain[height].Hash())
+		if len(locator) >= 10 {
+			step *= 2
+		}
+	}
+	return append(locator, chain[0].Hash())
+}
+
+// findLocator returns the height of the first locator entry that is on
+// chain, or -1 if none is.
+func (bc *BlockChain) findLocator(chain []*Block, locator [][32]byte) int {
+	for _, hash := range locator {
+		b, err := bc.store.BlockByHash(hash)
+		if err != nil {
+			continue
+		}
+		if height := blockHeight(chain, hash, b.header.height); height >= 0 {
+			return height
+		}
+	}
+	return -1
+}
+
+// blockHeight returns the height of the block with hash on chain, or -1 if
+// it is not on it.
+func blockHeight(chain []*Block, hash [32]byte, height uint64) int {
+	if height < uint64(len(chain)) && chain[height].Hash() == hash {
+		return int(height)
+	}
+	return -1
+}
+
+func clampRange(start int, count int, max int, length int) (int, int) {
+	if start < 0 {
+		start = 0
+	}
+	if count <= 0 || count > max {
+		count = max
+	}
+	end := start + count
+	if end > length {
+		end = length
+	}
+	if start > end {
+		start = end
+	}
+	return start, end
+}
+
+// HeadersAfter returns up to count headers following the fork point named
+// by locator. A locator with no known hash starts from genesis.
+func (bc *BlockChain) HeadersAfter(locator [][32]byte, count int) []*BlockHeader {
+	chain := bc.Chain()
+	return headersRange(chain, bc.findLocator(chain, locator)+1, count)
+}
+
+func (bc *BlockChain) Headers(start int, count int) []*BlockHeader {
+	return headersRange(bc.Chain(), start, count)
+}
+
+func headersRange(chain []*Block, start int, count int) []*BlockHeader {
+	start, end := clampRange(start, count, MAX_HEADERS_PER_REQUEST, len(chain))
+	return headersOf(chain[start:end])
+}
+
+func (bc *BlockChain) Blocks(start int, count int) []*Block {
+	return blocksRange(bc.Chain(), start, count)
+}
+
+func blocksRange(chain []*Block, start int, count int) []*Block {
+	start, end := clampRange(start, count, MAX_BLOCKS_PER_REQUEST, len(chain))
+	return chain[start:end]
+}
+
+// BlocksAfter returns up to count blocks following the block with hash.
+func (bc *BlockChain) BlocksAfter(hash [32]byte, count int) ([]*Block, error) {
+	b, err := bc.store.BlockByHash(hash)
+	if err != nil {
+		return nil, err
+	}
+	chain := bc.Chain()
+	height := blockHeight(chain, hash, b.header.height)
+	if height < 0 {
+		return nil, fmt.Errorf("block %x is no longer on the chain", hash)
+	}
+	return blocksRange(chain, height+1, count), nil
+}
+
+func getJSON(endpoint string, v interface{}) error {
+	resp, err := peerClient.Get(endpoint)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("GET %s: %s", endpoint, resp.Status)
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+func encodeLocator(locator [][32]byte) string {
+	hashes := make([]string, len(locator))
+	for i, hash := range locator {
+		hashes[i] = fmt.Sprintf("%x", hash)
+	}
+	return strings.Join(hashes, ",")
+}
+
+func DecodeLocator(s string) ([][32]byte, error) {
+	locator := make([][32]byte, 0)
+	if s == "" {
+		return locator, nil
+	}
+	for _, part := range strings.Split(s, ",") {
+		hash, err := DecodeHash(part)
+		if err != nil {
+			return nil, err
+		}
+		locator = append(locator, hash)
+	}
+	return locator, nil
+}
+
+// syncFrom downloads the part of peer's chain that the local chain lacks.
+// Headers are fetched and checked first, so blocks are only downloaded from
+// a peer whose branch really has more work; each block is then validated
+// on top of the state at the fork point before the switch is made.
+func (bc *BlockChain) syncFrom(peer string) error {
+	chain := bc.Chain()
+	locator := locatorOf(chain)
+	var headers []*BlockHeader
+	ancestor := -1
+	for {
+		var hr HeadersResponse
+		endpoint := fmt.Sprintf("http://%s/headers?locator=%s&count=%d", peer,
+			url.QueryEscape(encodeLocator(locator)), MAX_HEADERS_PER_REQUEST)
+		if err := getJSON(endpoint, &hr); err != nil {
+			return err
+		}
+		if len(hr.Headers) == 0 {
+			break
+		}
+		if headers == nil {
+			first := hr.Headers[0]
+			if first.height == 0 {
+				ancestor = -1
+			} else if ancestor = blockHeight(chain, first.previousHash, first.height-1); ancestor < 0 {
+				return fmt.Errorf("peer %s: headers do not connect to the local chain", peer)
+			}
+			headers = headersOf(chain[:ancestor+1])
+		}
+		for _, h := range hr.Headers {
+			if err := bc.validHeader(headers, h); err != nil {
+				return fmt.Errorf("peer %s: %w", peer, err)
+			}
+			headers = append(headers, h)
+		}
+		if len(hr.Headers) < MAX_HEADERS_PER_REQUEST {
+			break
+		}
+		locator = [][32]byte{headers[len(headers)-1].Hash()}
+	}
+	if headers == nil || HeadersWork(headers).Cmp(ChainWork(chain)) <= 0 {
+		return ErrNotEnoughWork
+	}
+
+	bc.mux.Lock()
+	if ancestor >= len(bc.chain) || (ancestor >= 0 && bc.chain[ancestor] != chain[ancestor]) {
+		bc.mux.Unlock()
+		return fmt.Errorf("peer %s: the local chain changed during the sync", peer)
+	}
+	state := bc.stateAt(ancestor)
+	bc.mux.Unlock()
+	candidate := make([]*Block, 0, len(headers))
+	candidate = append(candidate, chain[:ancestor+1]...)
+	for len(candidate) < len(headers) {
+		var br BlocksResponse
+		endpoint := fmt.Sprintf("http://%s/blocks?start=%d&count=%d", peer, len(candidate), MAX_BLOCKS_PER_REQUEST)
+		if err := getJSON(endpoint, &br); err != nil {
+			return err
+		}
+		if len(br.Blocks) == 0 {
+			return fmt.Errorf("peer %s: missing block %d", peer, len(candidate))
+		}
+		for _, b := range br.Blocks {
+			height := len(candidate)
+			if height >= len(headers) {
+				break
+			}
+			if b.Hash() != headers[height].Hash() {
+				return fmt.Errorf("peer %s: block %d does not match its header", peer, height)
+			}
+			if err := validBlockStructure(b, height, bc.params); err != nil {
+				return fmt.Errorf("peer %s: %w", peer, err)
+			}
+			if _, err := state.connectBlock(height, b); err != nil {
+				return fmt.Errorf("peer %s: %w", peer, err)
+			}
+			candidate = append(candidate, b)
+		}
+	}
+
+	bc.mux.Lock()
+	defer bc.mux.Unlock()
+	if ChainWork(candidate).Cmp(ChainWork(bc.chain)) <= 0 {
+		return ErrNotEnoughWork
+	}
+	return bc.reorganize(candidate)
+}
+
+// ResolveConflicts asks every neighbor for its tip and syncs from the one
+// carrying the most cumulative work. A neighbor's chain only wins with
+// strictly more work than the local one; between neighbors with equal work
+// the lower tip hash is tried first so every node makes the same choice.
+func (bc *BlockChain) ResolveConflicts() bool {
+	type candidate struct {
+		peer string
+		tip  *TipResponse
+	}
+	localWork := ChainWork(bc.Chain())
+	candidates := make([]candidate, 0)
+	for _, n := range bc.Neighbors() {
+		var tip TipResponse
+		if err := getJSON(fmt.Sprintf("http://%s/tip", n), &tip); err != nil {
+			log.Printf("ERROR: %v", err)
+			continue
+		}
+		if err := bc.checkTipGenesis(&tip); err != nil {
+			log.Printf("action=resolve_conflicts, peer=%s, status=ignored, reason=%v", n, err)
+			continue
+		}
+		if tip.Work.Cmp(localWork) > 0 {
+			candidates = append(candidates, candidate{n, &tip})
+		}
+	}
+	sort.Slice(candidates, func(i, j int) bool {
+		if c := candidates[i].tip.Work.Cmp(candidates[j].tip.Work); c != 0 {
+			return c > 0
+		}
+		return bytes.Compare(candidates[i].tip.Hash[:], candidates[j].tip.Hash[:]) < 0
+	})
+	for _, c := range candidates {
+		err := bc.syncFrom(c.peer)
+		if err == nil {
+			log.Printf("Resolve conflicts replaced")
+			return true
+		}
+		log.Printf("ERROR: sync from %s failed: %v", c.peer, err)
+	}
+	log.Printf("Resolve conflicts not replaced")
+	return false
+}
@@ -0,0 +1,98 @@
+package block
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// peerServer serves the endpoints syncFrom uses from bc.
+func peerServer(t *testing.T, bc *BlockChain) string {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		q := req.URL.Query()
+		count, _ := strconv.Atoi(q.Get("count"))
+		switch req.URL.Path {
+		case "/headers":
+			locator, err := DecodeLocator(q.Get("locator"))
+			if err != nil {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			json.NewEncoder(w).Encode(&HeadersResponse{Headers: bc.HeadersAfter(locator, count)})
+		case "/blocks":
+			start, _ := strconv.Atoi(q.Get("start"))
+			json.NewEncoder(w).Encode(&BlocksResponse{Blocks: bc.Blocks(start, count)})
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	t.Cleanup(srv.Close)
+	return strings.TrimPrefix(srv.URL, "http://")
+}
+
+func TestChainReadsDuringMining(t *testing.T) {
+	bc := newTestChain(t, DefaultChainParams())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 5; i++ {
+			bc.Mining()
+		}
+	}()
+	for {
+		select {
+		case <-done:
+			if tip := bc.Tip(); tip.Height != 5 {
+				t.Fatalf("tip height = %d, want 5", tip.Height)
+			}
+			return
+		default:
+		}
+		bc.Tip()
+		locator := bc.Locator()
+		bc.Headers(0, 0)
+		bc.HeadersAfter(locator, 0)
+		if _, err := bc.BlocksAfter(bc.GenesisHash(), 0); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestSyncFromPeer(t *testing.T) {
+	params := DefaultChainParams()
+	peer := newTestChain(t, params)
+	mineBlocks(t, peer, 3)
+	bc := newTestChain(t, params)
+	mineBlocks(t, bc, 1)
+
+	if err := bc.syncFrom(peerServer(t, peer)); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := bc.Tip().Hash, peer.Tip().Hash; got != want {
+		t.Fatalf("tip = %x, want %x", got, want)
+	}
+	if err := bc.syncFrom(peerServer(t, peer)); err != ErrNotEnoughWork {
+		t.Fatalf("second sync: %v, want %v", err, ErrNotEnoughWork)
+	}
+}
+
+func TestGetJSONTimesOut(t *testing.T) {
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		<-release
+	}))
+	t.Cleanup(srv.Close)
+	t.Cleanup(func() { close(release) })
+	client := peerClient
+	peerClient = &http.Client{Timeout: 50 * time.Millisecond}
+	defer func() { peerClient = client }()
+
+	var tr TipResponse
+	if err := getJSON(srv.URL+"/tip", &tr); err == nil {
+		t.Fatal("request to a silent peer did not time out")
+	}
+}
@@ -0,0 +1,281 @@
+package block
+
+import (
+	"crypto/ecdsa"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"goblockchain/utils"
+	"strings"
+)
+
+type Transaction struct {
+	senderBlockchainAddress    string
+	recipientBlockchainAddress string
+	value                      utils.Amount
+	fee                        utils.Amount
+	nonce                      uint64
+	senderPublicKey            *ecdsa.PublicKey
+	signature                  *utils.Signature
+	coinbase                   bool
+	inputs                     []utils.OutPoint
+	outputs                    []utils.TxOutput
+}
+
+func NewTransaction(sender string, recipient string, value utils.Amount, fee utils.Amount, nonce uint64,
+	senderPublicKey *ecdsa.PublicKey, s *utils.Signature) *Transaction {
+	return &Transaction{sender, recipient, value, fee, nonce, senderPublicKey, s, false, nil, nil}
+}
+
+// NewUTXOTransaction creates a transaction of the UTXO ledger, which spends
+// outputs of sender and creates new ones. The inputs have to add up to the
+// outputs plus fee.
+func NewUTXOTransaction(sender string, inputs []utils.OutPoint, outputs []utils.TxOutput, fee utils.Amount,
+	senderPublicKey *ecdsa.PublicKey, s *utils.Signature) *Transaction {
+	return &Transaction{sender, "", 0, fee, 0, senderPublicKey, s, false, inputs, outputs}
+}
+
+// NewCoinbase creates the unsigned transaction that pays the miner of the
+// block at height. The height takes the place of the nonce so that every
+// coinbase has its own ID.
+func NewCoinbase(recipient string, value utils.Amount, height uint64) *Transaction {
+	return &Transaction{MINING_SENDER, recipient, value, 0, height, nil, nil, true, nil, nil}
+}
+
+// IsCoinbase reports whether t mints coin rather than moving it.
+func (t *Transaction) IsCoinbase() bool {
+	return t.coinbase
+}
+
+func (t *Transaction) Inputs() []utils.OutPoint {
+	return t.inputs
+}
+
+// Outputs are the outputs t creates on the UTXO ledger. A coinbase pays
+// its single output to the miner.
+func (t *Transaction) Outputs() []utils.TxOutput {
+	if t.coinbase {
+		return []utils.TxOutput{{Address: t.recipientBlockchainAddress, Value: t.value}}
+	}
+	return t.outputs
+}
+
+// Fee is what the sender pays the miner on top of value.
+func (t *Transaction) Fee() utils.Amount {
+	return t.fee
+}
+
+// Cost is everything the transaction takes from the sender's balance.
+func (t *Transaction) Cost() (utils.Amount, error) {
+	return utils.AddAmount(t.value, t.fee)
+}
+
+func (t *Transaction) Nonce() uint64 {
+	return t.nonce
+}
+
+func (t *Transaction) SenderPublicKey() *ecdsa.PublicKey {
+	return t.senderPublicKey
+}
+
+func (t *Transaction) Signature() *utils.Signature {
+	return t.signature
+}
+
+func (t *Transaction) Print() {
+	fmt.Printf("%s\n", strings.Repeat("-", 40))
+	fmt.Printf("sender_blockchain_address    %s\n", t.senderBlockchainAddress)
+	fmt.Printf("recipient_blockchain_address %s\n", t.recipientBlockchainAddress)
+	fmt.Printf("value                        %d\n", t.value)
+	fmt.Printf("fee                          %d\n", t.fee)
+	fmt.Printf("nonce                        %d\n", t.nonce)
+	for _, in := range t.inputs {
+		fmt.Printf("input                        %s\n", in)
+	}
+	for _, out := range t.outputs {
+		fmt.Printf("output                       %s %d\n", out.Address, out.Value)
+	}
+}
+
+// PUBLIC_KEY_SIZE and SIGNATURE_SIZE are the sizes of the P-256 public key
+// and signature a transaction carries besides its canonical encoding.
+const (
+	PUBLIC_KEY_SIZE = 64
+	SIGNATURE_SIZE  = 64
+)
+
+// Size is the length of the canonical encoding of t plus the public key and
+// signature that come with it, the unit fee rates and block sizes are
+// measured in. A coinbase carries neither.
+func (t *Transaction) Size() uint64 {
+	size := uint64(len(t.Bytes()))
+	if !t.coinbase {
+		size += PUBLIC_KEY_SIZE + SIGNATURE_SIZE
+	}
+	return size
+}
+
+// Bytes is the canonical binary encoding of the transfer itself, without
+// the public key or signature carried alongside it.
+func (t *Transaction) Bytes() []byte {
+	return utils.EncodeTransaction(t.senderBlockchainAddress, t.recipientBlockchainAddress, t.value, t.fee,
+		t.nonce, t.coinbase, t.inputs, t.outputs)
+}
+
+// SigningHash is the digest the sender signs, the hash of Bytes.
+func (t *Transaction) SigningHash() [32]byte {
+	return sha256.Sum256(t.Bytes())
+}
+
+func (t *Transaction) VerifySignature() bool {
+	h := t.SigningHash()
+	return ecdsa.Verify(t.senderPublicKey, h[:], t.signature.R, t.signature.S)
+}
+
+// ID identifies a transaction by its signed content, so two copies of the
+// same signed transfer share an ID.
+func (t *Transaction) ID() [32]byte {
+	return t.SigningHash()
+}
+
+func (t *Transaction) MarshalJSON() ([]byte, error) {
+	var publicKey, signature string
+	if t.senderPublicKey != nil {
+		publicKey = utils.PublicKeyString(t.senderPublicKey)
+	}
+	if t.signature != nil {
+		signature = t.signature.String()
+	}
+	return json.Marshal(struct {
+		ID        string           `json:"id"`
+		Sender    string           `json:"sender_blockchain_address"`
+		Recipient string           `json:"recipient_blockchain_address"`
+		Value     utils.Amount     `json:"value"`
+		Fee       utils.Amount     `json:"fee"`
+		Nonce     uint64           `json:"nonce"`
+		PublicKey string           `json:"sender_public_key,omitempty"`
+		Signature string           `json:"signature,omitempty"`
+		Coinbase  bool             `json:"coinbase,omitempty"`
+		Inputs    []utils.OutPoint `json:"inputs,omitempty"`
+		Outputs   []utils.TxOutput `json:"outputs,omitempty"`
+	}{
+		ID:        fmt.Sprintf("%x", t.ID()),
+		Sender:    t.senderBlockchainAddress,
+		Recipient: t.recipientBlockchainAddress,
+		Value:     t.value,
+		Fee:       t.fee,
+		Nonce:     t.nonce,
+		PublicKey: publicKey,
+		Signature: signature,
+		Coinbase:  t.coinbase,
+		Inputs:    t.inputs,
+		Outputs:   t.outputs,
+	})
+}
+
+func (t *Transaction) UnmarshalJSON(data []byte) error {
+	var publicKey, signature string
+	v := struct {
+		Sender    *string           `json:"sender_blockchain_address"`
+		Recipient *string           `json:"recipient_blockchain_address"`
+		Value     *utils.Amount     `json:"value"`
+		Fee       *utils.Amount     `json:"fee"`
+		Nonce     *uint64           `json:"nonce"`
+		PublicKey *string           `json:"sender_public_key"`
+		Signature *string           `json:"signature"`
+		Coinbase  *bool             `json:"coinbase"`
+		Inputs    *[]utils.OutPoint `json:"inputs"`
+		Outputs   *[]utils.TxOutput `json:"outputs"`
+	}{
+		Sender:    &t.senderBlockchainAddress,
+		Recipient: &t.recipientBlockchainAddress,
+		Value:     &t.value,
+		Fee:       &t.fee,
+		Nonce:     &t.nonce,
+		PublicKey: &publicKey,
+		Signature: &signature,
+		Coinbase:  &t.coinbase,
+		Inputs:    &t.inputs,
+		Outputs:   &t.outputs,
+	}
+	if err := json.Unmarshal(data, &v); err != nil {
+		return err
+	}
+	if publicKey != "" {
+		if !isHexPair(publicKey) {
+			return errors.New("invalid sender_public_key")
+		}
+		t.senderPublicKey = utils.PublicKeyFromString(publicKey)
+	}
+	if signature != "" {
+		if !isHexPair(signature) {
+			return errors.New("invalid signature")
+		}
+		t.signature = utils.SignatureFromString(signature)
+	}
+	return nil
+}
+
+// TransactionsResponse answers GET /transactions with the pending
+// transactions. Unlike a transaction inside a block, which peers exchange
+// in base units, its amounts are decimal strings with Decimals places.
+type TransactionsResponse struct {
+	Transactions []*Transaction
+	Decimals     uint8
+}
+
+func (tr *TransactionsResponse) MarshalJSON() ([]byte, error) {
+	type pendingTransaction struct {
+		ID        string           `json:"id"`
+		Sender    string           `json:"sender_blockchain_address"`
+		Recipient string           `json:"recipient_blockchain_address"`
+		Value     string           `json:"value"`
+		Fee       string           `json:"fee"`
+		Nonce     uint64           `json:"nonce"`
+		PublicKey string           `json:"sender_public_key,omitempty"`
+		Signature string           `json:"signature,omitempty"`
+		Inputs    []utils.OutPoint `json:"inputs,omitempty"`
+		Outputs   []OutputRequest  `json:"outputs,omitempty"`
+	}
+	transactions := make([]pendingTransaction, len(tr.Transactions))
+	for i, t := range tr.Transactions {
+		var outputs []OutputRequest
+		for _, o := range t.outputs {
+			outputs = append(outputs, OutputRequest{Address: o.Address, Value: utils.FormatAmount(o.Value, tr.Decimals)})
+		}
+		transactions[i] = pendingTransaction{
+			ID:        fmt.Sprintf("%x", t.ID()),
+			Sender:    t.senderBlockchainAddress,
+			Recipient: t.recipientBlockchainAddress,
+			Value:     utils.FormatAmount(t.value, tr.Decimals),
+			Fee:       utils.FormatAmount(t.fee, tr.Decimals),
+			Nonce:     t.nonce,
+			PublicKey: utils.PublicKeyString(t.senderPublicKey),
+			Signature: t.signature.String(),
+			Inputs:    t.inputs,
+			Outputs:   outputs,
+		}
+	}
+	return json.Marshal(struct {
+		Transactions []pendingTransaction `json:"transactions"`
+		Lenght       int                  `json:"lenght"`
+	}{
+		Transactions: transactions,
+		Lenght:       len(transactions),
+	})
+}
+
+// isHexPair reports whether s has the shape of two 32-byte hex numbers, the
+// encoding used for both public keys and signatures.
+func isHexPair(s string) bool {
+	if len(s) != 128 {
+		return false
+	}
+	for _, c := range s {
+		if !strings.ContainsRune("0123456789abcdefABCDEF", c) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,60 @@
+package block
+
+import (
+	"encoding/json"
+	"errors"
+	"goblockchain/wallet"
+	"strings"
+	"testing"
+)
+
+func TestTransactionSizeIsCanonical(t *testing.T) {
+	a, b := wallet.NewWallet(), wallet.NewWallet()
+	tx := signedTransfer(a, b, 1000, 100000, 0)
+	if got, want := tx.Size(), uint64(len(tx.Bytes())+PUBLIC_KEY_SIZE+SIGNATURE_SIZE); got != want {
+		t.Fatalf("size %d, want %d", got, want)
+	}
+	coinbase := NewCoinbase(b.BlockChainAddress(), 1000, 1)
+	if got, want := coinbase.Size(), uint64(len(coinbase.Bytes())); got != want {
+		t.Fatalf("coinbase size %d, want %d", got, want)
+	}
+}
+
+func TestMiningRespectsBlockSize(t *testing.T) {
+	a, b := wallet.NewWallet(), wallet.NewWallet()
+	first, second := signedTransfer(a, b, 1000, 100000, 0), signedTransfer(a, b, 1000, 100000, 1)
+	params := fundedParams(a)
+	params.MaxBlockSize = HEADER_SIZE + maxCoinbaseSize + first.Size()
+	bc := newTestChain(t, params)
+	for _, tx := range []*Transaction{first, second} {
+		if err := bc.ReceiveTransaction(tx, 1, ""); err != nil {
+			t.Fatal(err)
+		}
+	}
+	mined := mineBlocks(t, bc, 1)[0]
+	if n := len(mined.transactions); n != 2 {
+		t.Fatalf("block holds %d transactions, want the coinbase and one transfer", n)
+	}
+	if pool := bc.TransactionPool(); len(pool) != 1 || pool[0].ID() != second.ID() {
+		t.Fatalf("pool holds %d transactions, want the second transfer", len(pool))
+	}
+
+	oversized := NewBlock(mined.header.height, mined.header.previousHash, mined.header.timestamp,
+		append(mined.transactions, second), mined.header.bits)
+	if err := validBlockStructure(oversized, int(mined.header.height), params); !errors.Is(err, ErrBlockTooLarge) {
+		t.Fatalf("oversized block: %v, want %v", err, ErrBlockTooLarge)
+	}
+}
+
+func TestTransactionsResponseCarriesDecimalAmounts(t *testing.T) {
+	a, b := wallet.NewWallet(), wallet.NewWallet()
+	m, err := json.Marshal(&TransactionsResponse{Transactions: []*Transaction{signedTransfer(a, b, 1250, 5, 0)}, Decimals: 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{`"value":"1.25"`, `"fee":"0.005"`, `"lenght":1`} {
+		if !strings.Contains(string(m), want) {
+			t.Fatalf("response %s lacks %s", m, want)
+		}
+	}
+}
@@ -0,0 +1,244 @@
+package block
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"goblockchain/utils"
+	"goblockchain/wallet"
+	"sort"
+)
+
+const (
+	LEDGER_ACCOUNT = "account"
+	LEDGER_UTXO    = "utxo"
+)
+
+var (
+	ErrWrongLedger   = errors.New("transaction does not fit the ledger of this chain")
+	ErrMissingInput  = errors.New("input is not an unspent output")
+	ErrDoubleSpend   = errors.New("output is already spent")
+	ErrValueMismatch = errors.New("inputs do not add up to outputs plus fee")
+)
+
+// utxoSet holds the unspent outputs of a chain, indexed by owner so the
+// outputs of an address are found without a scan. The chainState that
+// owns it does the locking.
+type utxoSet struct {
+	outputs   map[utils.OutPoint]utils.TxOutput
+	byAddress map[string]map[utils.OutPoint]bool
+}
+
+func newUTXOSet() *utxoSet {
+	return &utxoSet{
+		outputs:   make(map[utils.OutPoint]utils.TxOutput),
+		byAddress: make(map[string]map[utils.OutPoint]bool),
+	}
+}
+
+func (u *utxoSet) clone() *utxoSet {
+	c := newUTXOSet()
+	for o, out := range u.outputs {
+		c.add(o, out)
+	}
+	return c
+}
+
+func (u *utxoSet) get(o utils.OutPoint) (utils.TxOutput, bool) {
+	out, ok := u.outputs[o]
+	return out, ok
+}
+
+func (u *utxoSet) add(o utils.OutPoint, out utils.TxOutput) {
+	u.outputs[o] = out
+	owned := u.byAddress[out.Address]
+	if owned == nil {
+		owned = make(map[utils.OutPoint]bool)
+		u.byAddress[out.Address] = owned
+	}
+	owned[o] = true
+}
+
+func (u *utxoSet) remove(o utils.OutPoint) {
+	out, ok := u.outputs[o]
+	if !ok {
+		return
+	}
+	delete(u.outputs, o)
+	delete(u.byAddress[out.Address], o)
+	if len(u.byAddress[out.Address]) == 0 {
+		delete(u.byAddress, out.Address)
+	}
+}
+
+// outPoints lists every unspent output in sorted order.
+func (u *utxoSet) outPoints() []utils.OutPoint {
+	outPoints := make([]utils.OutPoint, 0, len(u.outputs))
+	for o := range u.outputs {
+		outPoints = append(outPoints, o)
+	}
+	sort.Slice(outPoints, func(i, j int) bool { return compareOutPoints(outPoints[i], outPoints[j]) })
+	return outPoints
+}
+
+// unspent lists the outputs paying address, sorted by out point so callers
+// see a stable list.
+func (u *utxoSet) unspent(address string) []*utils.UnspentOutput {
+	unspent := make([]*utils.UnspentOutput, 0, len(u.byAddress[address]))
+	for o := range u.byAddress[address] {
+		unspent = append(unspent, &utils.UnspentOutput{OutPoint: o, Output: u.outputs[o]})
+	}
+	sort.Slice(unspent, func(i, j int) bool {
+		return compareOutPoints(unspent[i].OutPoint, unspent[j].OutPoint)
+	})
+	return unspent
+}
+
+func sumOutputs(unspent []*utils.UnspentOutput) (utils.Amount, error) {
+	var total utils.Amount
+	var err error
+	for _, u := range unspent {
+		if total, err = utils.AddAmount(total, u.Output.Value); err != nil {
+			return 0, err
+		}
+	}
+	return total, nil
+}
+
+// checkUTXOTransaction validates the inputs and outputs of t, looking the
+// spent outputs up with lookup. Signature and sender are checked by the
+// caller.
+func checkUTXOTransaction(t *Transaction, lookup func(utils.OutPoint) (utils.TxOutput, bool)) error {
+	if t.recipientBlockchainAddress != "" || t.value != 0 || t.nonce != 0 {
+		return fmt.Errorf("%w: UTXO transactions carry no recipient, value or nonce", ErrWrongLedger)
+	}
+	if len(t.inputs) == 0 || len(t.outputs) == 0 {
+		return fmt.Errorf("%w: inputs and outputs are required", ErrWrongLedger)
+	}
+	var in, out utils.Amount
+	var err error
+	seen := make(map[utils.OutPoint]bool, len(t.inputs))
+	for _, o := range t.inputs {
+		if seen[o] {
+			return fmt.Errorf("%w: %s spent twice", ErrDoubleSpend, o)
+		}
+		seen[o] = true
+		spent, ok := lookup(o)
+		if !ok {
+			return fmt.Errorf("%w: %s", ErrMissingInput, o)
+		}
+		if spent.Address != t.senderBlockchainAddress {
+			return fmt.Errorf("%w: %s belongs to %s", ErrSenderMismatch, o, spent.Address)
+		}
+		if in, err = utils.AddAmount(in, spent.Value); err != nil {
+			return err
+		}
+	}
+	for _, o := range t.outputs {
+		if o.Value <= 0 {
+			return ErrInvalidValue
+		}
+		if err := wallet.ValidateAddress(o.Address); err != nil {
+			return fmt.Errorf("%w: %v", ErrInvalidRecipient, err)
+		}
+		if out, err = utils.AddAmount(out, o.Value); err != nil {
+			return err
+		}
+	}
+	if out, err = utils.AddAmount(out, t.fee); err != nil {
+		return err
+	}
+	if in != out {
+		return fmt.Errorf("%w: inputs %d, outputs and fee %d", ErrValueMismatch, in, out)
+	}
+	return nil
+}
+
+func (bc *BlockChain) usesUTXO() bool {
+	return bc.params.Ledger == LEDGER_UTXO
+}
+
+// UnspentOutputs lists the confirmed outputs of blockchainAddress that no
+// pool transaction spends yet.
+func (bc *BlockChain) UnspentOutputs(blockchainAddress string) []*utils.UnspentOutput {
+	bc.mux.Lock()
+	defer bc.mux.Unlock()
+	return bc.spendableOutputs(blockchainAddress)
+}
+
+func (bc *BlockChain) spendableOutputs(blockchainAddress string) []*utils.UnspentOutput {
+	unspent := make([]*utils.UnspentOutput, 0)
+	for _, u := range bc.state.unspent(blockchainAddress) {
+		if !bc.transactionPool.spends(u.OutPoint) {
+			unspent = append(unspent, u)
+		}
+	}
+	return unspent
+}
+
+// UTXOResponse answers GET /utxos. On an account ledger it carries no
+// outputs, which tells wallets to fall back to nonces. Values travel as
+// decimal strings with Decimals places, which a decoder has to be given.
+type UTXOResponse struct {
+	Ledger   string
+	UTXOs    []*utils.UnspentOutput
+	Decimals uint8
+}
+
+// unspentOutputJSON is an unspent output as GET /utxos carries it.
+type unspentOutputJSON struct {
+	TxID    string `json:"tx_id"`
+	Index   uint32 `json:"index"`
+	Address string `json:"address"`
+	Value   string `json:"value"`
+}
+
+func (ur *UTXOResponse) MarshalJSON() ([]byte, error) {
+	utxos := make([]unspentOutputJSON, len(ur.UTXOs))
+	for i, u := range ur.UTXOs {
+		utxos[i] = unspentOutputJSON{
+			TxID:    hex.EncodeToString(u.OutPoint.TxID[:]),
+			Index:   u.OutPoint.Index,
+			Address: u.Output.Address,
+			Value:   utils.FormatAmount(u.Output.Value, ur.Decimals),
+		}
+	}
+	return json.Marshal(struct {
+		Ledger string              `json:"ledger"`
+		UTXOs  []unspentOutputJSON `json:"utxos"`
+	}{
+		Ledger: ur.Ledger,
+		UTXOs:  utxos,
+	})
+}
+
+func (ur *UTXOResponse) UnmarshalJSON(data []byte) error {
+	var utxos []unspentOutputJSON
+	v := &struct {
+		Ledger *string              `json:"ledger"`
+		UTXOs  *[]unspentOutputJSON `json:"utxos"`
+	}{
+		Ledger: &ur.Ledger,
+		UTXOs:  &utxos,
+	}
+	if err := json.Unmarshal(data, v); err != nil {
+		return err
+	}
+	ur.UTXOs = make([]*utils.UnspentOutput, len(utxos))
+	for i, u := range utxos {
+		txID, err := DecodeHash(u.TxID)
+		if err != nil {
+			return fmt.Errorf("utxos: tx_id: %w", err)
+		}
+		value, err := utils.ParseAmount(u.Value, ur.Decimals)
+		if err != nil {
+			return fmt.Errorf("utxos: value: %w", err)
+		}
+		ur.UTXOs[i] = &utils.UnspentOutput{
+			OutPoint: utils.OutPoint{TxID: txID, Index: u.Index},
+			Output:   utils.TxOutput{Address: u.Address, Value: value},
+		}
+	}
+	return nil
+}
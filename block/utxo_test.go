@@ -0,0 +1,74 @@
+package block
+
+import (
+	"encoding/json"
+	"errors"
+	"goblockchain/utils"
+	"goblockchain/wallet"
+	"strings"
+	"testing"
+)
+
+func TestUTXOResponseCarriesDecimalValues(t *testing.T) {
+	u := &utils.UnspentOutput{
+		OutPoint: utils.OutPoint{TxID: vectorHash(0x11), Index: 2},
+		Output:   utils.TxOutput{Address: "1BvBMSEYstWetqTFn5Au4m4GFg7xJaNVN2", Value: 150},
+	}
+	m, err := json.Marshal(&UTXOResponse{Ledger: LEDGER_UTXO, UTXOs: []*utils.UnspentOutput{u}, Decimals: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(m), `"value":"1.5"`) {
+		t.Fatalf("response %s does not carry the value as a decimal string", m)
+	}
+
+	ur := UTXOResponse{Decimals: 2}
+	if err := json.Unmarshal(m, &ur); err != nil {
+		t.Fatal(err)
+	}
+	if ur.Ledger != LEDGER_UTXO || len(ur.UTXOs) != 1 || *ur.UTXOs[0] != *u {
+		t.Fatalf("decoded %+v, want %+v", ur.UTXOs, u)
+	}
+}
+
+// signedSpend is a UTXO transaction of from signed by its wallet.
+func signedSpend(from *wallet.Wallet, inputs []utils.OutPoint, outputs []utils.TxOutput, fee utils.Amount) *Transaction {
+	t := wallet.NewUTXOTransaction(from.PrivateKey(), from.PublicKey(), from.BlockChainAddress(), inputs, outputs, fee)
+	return NewUTXOTransaction(from.BlockChainAddress(), inputs, outputs, fee, from.PublicKey(), t.GenerateSignature())
+}
+
+func TestUTXODoubleSpendRejected(t *testing.T) {
+	a, b, c := wallet.NewWallet(), wallet.NewWallet(), wallet.NewWallet()
+	params := fundedParams(a)
+	params.Ledger = LEDGER_UTXO
+	bc := newTestChain(t, params)
+	unspent := bc.UnspentOutputs(a.BlockChainAddress())
+	if len(unspent) != 1 {
+		t.Fatalf("%d unspent outputs, want the allocation", len(unspent))
+	}
+	in := unspent[0]
+
+	twice := signedSpend(a, []utils.OutPoint{in.OutPoint, in.OutPoint},
+		[]utils.TxOutput{{Address: b.BlockChainAddress(), Value: 2*in.Output.Value - 100000}}, 100000)
+	if err := bc.ReceiveTransaction(twice, 1, ""); !errors.Is(err, ErrDoubleSpend) {
+		t.Fatalf("input spent twice in one transaction: %v, want %v", err, ErrDoubleSpend)
+	}
+
+	inputs, outputs, err := wallet.SelectOutputs(unspent, a.BlockChainAddress(), b.BlockChainAddress(), 1000, 100000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := bc.ReceiveTransaction(signedSpend(a, inputs, outputs, 100000), 1, ""); err != nil {
+		t.Fatal(err)
+	}
+	inputs, outputs, err = wallet.SelectOutputs(unspent, a.BlockChainAddress(), c.BlockChainAddress(), 1000, 100000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := bc.ReceiveTransaction(signedSpend(a, inputs, outputs, 100000), 1, ""); !errors.Is(err, ErrDoubleSpend) {
+		t.Fatalf("input a pool transaction spends: %v, want %v", err, ErrDoubleSpend)
+	}
+	if n := len(bc.TransactionPool()); n != 1 {
+		t.Fatalf("pool holds %d transactions, want only the first spend", n)
+	}
+}
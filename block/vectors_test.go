@@ -0,0 +1,107 @@
+package block
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"goblockchain/utils"
+	"testing"
+)
+
+// encodingVector pins the canonical encoding of one transaction or header.
+// Every node has to produce exactly these bytes, so a change to the
+// encoding that would split the network fails TestEncodingVectors instead
+// of going unnoticed.
+type encodingVector struct {
+	name   string
+	encode func() []byte
+	bytes  string
+	hash   string
+}
+
+func vectorHash(b byte) [32]byte {
+	var h [32]byte
+	for i := range h {
+		h[i] = b
+	}
+	return h
+}
+
+var encodingVectors = []encodingVector{
+	{
+		name: "account transaction",
+		encode: func() []byte {
+			return NewTransaction("1A1zP1eP5QGefi2DMPTfTL5SLmv7DivfNa", "1BvBMSEYstWetqTFn5Au4m4GFg7xJaNVN2",
+				150000000, 2500, 7, nil, nil).Bytes()
+		},
+		bytes: "01000000223141317a5031655035514765666932444d505466544c35534c6d7637446976664e6100000022314276424d53455973745765747154466e354175346d3447466737784a614e564e320000000008f0d18000000000000009c40000000000000007000000000000000000",
+		hash:  "2021b2313281a54e015a8e0d2ee9751197fd2adebf0c47773f7a5960b8a2f03b",
+	},
+	{
+		name: "utxo transaction",
+		encode: func() []byte {
+			return NewUTXOTransaction("1A1zP1eP5QGefi2DMPTfTL5SLmv7DivfNa",
+				[]utils.OutPoint{{TxID: vectorHash(0xab), Index: 1}},
+				[]utils.TxOutput{
+					{Address: "1BvBMSEYstWetqTFn5Au4m4GFg7xJaNVN2", Value: 40000000},
+					{Address: "1A1zP1eP5QGefi2DMPTfTL5SLmv7DivfNa", Value: 9990000},
+				}, 10000, nil, nil).Bytes()
+		},
+		bytes: "01000000223141317a5031655035514765666932444d505466544c35534c6d7637446976664e61000000000000000000000000000000000000271000000000000000000000000001abababababababababababababababababababababababababababababababab000000010000000200000022314276424d53455973745765747154466e354175346d3447466737784a614e564e320000000002625a00000000223141317a5031655035514765666932444d505466544c35534c6d7637446976664e610000000000986f70",
+		hash:  "866a191bea07731ffc3f598e8ebf0e8e2d2e8d047b235216a1be65264d8e81c8",
+	},
+	{
+		name: "coinbase",
+		encode: func() []byte {
+			return NewCoinbase("1BvBMSEYstWetqTFn5Au4m4GFg7xJaNVN2", 100002500, 12).Bytes()
+		},
+		bytes: "010000000e54484520424c4f434b434841494e00000022314276424d53455973745765747154466e354175346d3447466737784a614e564e320000000005f5eac40000000000000000000000000000000c010000000000000000",
+		hash:  "2fbf22c3708d5d0d9d3d7705d2c60264219edf2abdc64f3d8b88693c1ca4b4d8",
+	},
+	{
+		name: "header",
+		encode: func() []byte {
+			h := &BlockHeader{
+				version:      2,
+				height:       12,
+				previousHash: vectorHash(0x01),
+				merkleRoot:   vectorHash(0x02),
+				stateRoot:    vectorHash(0x03),
+				timestamp:    GENESIS_TIMESTAMP,
+				bits:         0x1f0fffff,
+				nonce:        42,
+			}
+			return h.Bytes()
+		},
+		bytes: "00000002000000000000000c01010101010101010101010101010101010101010101010101010101010101010202020202020202020202020202020202020202020202020202020202020202030303030303030303030303030303030303030303030303030303030303030317a61017016500001f0fffff000000000000002a",
+		hash:  "c4cef8f2e872d78bbd8286d0d2bb85005395a00770fef6a232d543c84b5c42ae",
+	},
+}
+
+func TestEncodingVectors(t *testing.T) {
+	for _, v := range encodingVectors {
+		t.Run(v.name, func(t *testing.T) {
+			got := v.encode()
+			want, err := hex.DecodeString(v.bytes)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, want) {
+				t.Fatalf("got %x, want %s", got, v.bytes)
+			}
+			if hash := sha256.Sum256(got); hex.EncodeToString(hash[:]) != v.hash {
+				t.Fatalf("hash %x, want %s", hash, v.hash)
+			}
+		})
+	}
+}
+
+// TestTransactionIDIsSigningHash ties the ID of a transaction to the
+// pinned encoding, so that neither can change without the other.
+func TestTransactionIDIsSigningHash(t *testing.T) {
+	tx := NewTransaction("1A1zP1eP5QGefi2DMPTfTL5SLmv7DivfNa", "1BvBMSEYstWetqTFn5Au4m4GFg7xJaNVN2", 150000000, 2500, 7, nil, nil)
+	id := tx.ID()
+	if got, want := hex.EncodeToString(id[:]), encodingVectors[0].hash; got != want {
+		t.Fatalf("ID %s, want %s", got, want)
+	}
+}
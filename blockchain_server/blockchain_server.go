@@ -1,35 +1,72 @@
 package main
 
 import (
+	"bytes"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"goblockchain/block"
 	"goblockchain/utils"
 	"goblockchain/wallet"
 	"io"
 	"log"
+	"net"
 	"net/http"
+	"os"
+	"path/filepath"
 	"strconv"
+	"strings"
+	"time"
 )
 
+// MINER_KEY_FILE holds the private key of the miner's wallet in the data
+// directory of a node.
+const MINER_KEY_FILE = "miner.key"
+
 var cache map[string]*block.BlockChain = make(map[string]*block.BlockChain)
 
 type BlockchainServer struct {
-	port uint16
+	port          uint16
+	dataDir       string
+	params        *block.ChainParams
+	miningWorkers int
+	minRelayFee   uint64
+	mempoolSize   int
 }
 
-func NewBlockchainServer(port uint16) *BlockchainServer {
-	return &BlockchainServer{port}
+func NewBlockchainServer(port uint16, dataDir string, params *block.ChainParams, miningWorkers int, minRelayFee uint64, mempoolSize int) *BlockchainServer {
+	return &BlockchainServer{port, dataDir, params, miningWorkers, minRelayFee, mempoolSize}
 }
 
 func (bcs *BlockchainServer) Port() uint16 {
 	return bcs.port
 }
 
+func (bcs *BlockchainServer) DataDir() string {
+	return bcs.dataDir
+}
+
 func (bcs *BlockchainServer) GetBlockchain() *block.BlockChain {
 	bc, ok := cache["blockchain"]
 	if !ok {
-		minersWallet := wallet.NewWallet()
-		bc = block.NewBlockchain(minersWallet.BlockChainAddress(), bcs.port)
+		dir := filepath.Join(bcs.dataDir, strconv.Itoa(int(bcs.port)))
+		store, err := block.OpenFileStore(dir)
+		if err != nil {
+			log.Fatalf("ERROR: %v", err)
+		}
+		minersWallet, err := loadMinerWallet(dir)
+		if err != nil {
+			log.Fatalf("ERROR: %v", err)
+		}
+		bc, err = block.NewBlockchain(minersWallet.BlockChainAddress(), bcs.port, store, bcs.params)
+		if err != nil {
+			log.Fatalf("ERROR: %v", err)
+		}
+		if bcs.miningWorkers > 0 {
+			bc.SetMiningWorkers(bcs.miningWorkers)
+		}
+		bc.SetMinRelayFee(utils.Amount(bcs.minRelayFee))
+		bc.SetMempoolSize(bcs.mempoolSize)
 		cache["blockchain"] = bc
 		log.Printf("private_key %v", minersWallet.PrivateKeyStr())
 		log.Printf("public_key %v", minersWallet.PublicKeyStr())
@@ -38,6 +75,24 @@ func (bcs *BlockchainServer) GetBlockchain() *block.BlockChain {
 	return bc
 }
 
+// loadMinerWallet reads the miner's key from dir, creating it on the first
+// start, so that rewards mined before a restart stay spendable.
+func loadMinerWallet(dir string) (*wallet.Wallet, error) {
+	path := filepath.Join(dir, MINER_KEY_FILE)
+	data, err := os.ReadFile(path)
+	if err == nil {
+		return wallet.NewWalletFromPrivateKey(strings.TrimSpace(string(data)))
+	}
+	if !os.IsNotExist(err) {
+		return nil, err
+	}
+	w := wallet.NewWallet()
+	if err := os.WriteFile(path, []byte(w.PrivateKeyStr()+"\n"), 0o600); err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
 func(bcs *BlockchainServer) GetChain(w http.ResponseWriter, req *http.Request){
 	switch req.Method{
 	case http.MethodGet:
@@ -55,39 +110,33 @@ func (bcs *BlockchainServer) Transactions(w http.ResponseWriter, req *http.Reque
 	case http.MethodGet:
 		w.Header().Add("Content-Type", "application/json")
 		bc := bcs.GetBlockchain()
-		transactions := bc.TransactionPool()
-		m, _ := json.Marshal(struct {
-			Transactions []*block.Transaction `json:"transactions"`
-			Lenght       int                  `json:"lenght"`
-		}{
-			Transactions: transactions,
-			Lenght: len(transactions),
-		})
+		tr := &block.TransactionsResponse{Transactions: bc.TransactionPool(), Decimals: bc.Params().Decimals}
+		m, _ := tr.MarshalJSON()
 		io.WriteString(w, string(m[:]))
 	case http.MethodPost:
 		decoder := json.NewDecoder(req.Body)
 		var t block.TransactionRequest
 		err := decoder.Decode(&t)
-		t.GetTransactionRequest()
 		if err != nil {
 			log.Printf("ERROR: %v", err)
 			io.WriteString(w, string((utils.JsonStatus("fail"))))
 			return
 		}
-		if !t.Validate() { 
-			log.Println("ERROR: missing field(s)")
+		if !t.Validate() {
+			log.Println("ERROR: missing or malformed field(s)")
+			w.WriteHeader(http.StatusBadRequest)
+			io.WriteString(w, string(utils.JsonStatus("fail")))
 			return
 		}
+		t.GetTransactionRequest()
 
-		publicKey := utils.PublicKeyFromString(*t.SenderPublicKey)
-		signature := utils.SignatureFromString(*t.Signature)
-		bc := bcs.GetBlockchain()
-		isCreated := bc.CreateTransaction(*t.SenderBlockchainAddress, *t.RecipientBlockchainAddress, *t.Value, publicKey, signature)
+		err = bcs.submitTransaction(&t, block.TX_RELAY_TTL, "")
 		w.Header().Add("Content-Type", "application/json")
 		var m []byte
-		if !isCreated {
+		if err != nil {
+			log.Printf("ERROR: %v", err)
 			w.WriteHeader(http.StatusBadRequest)
-			m = utils.JsonStatus("fail")
+			m = utils.JsonError(err)
 		} else {
 			w.WriteHeader(http.StatusCreated)
 			m = utils.JsonStatus("success")
@@ -102,28 +151,39 @@ func (bcs *BlockchainServer) Transactions(w http.ResponseWriter, req *http.Reque
 			io.WriteString(w, string((utils.JsonStatus("fail"))))
 			return
 		}
-		if !t.Validate() { 
-			log.Println("ERROR: missing field(s)")
+		if !t.Validate() {
+			log.Println("ERROR: missing or malformed field(s)")
+			w.WriteHeader(http.StatusBadRequest)
+			io.WriteString(w, string(utils.JsonStatus("fail")))
 			return
 		}
-		publicKey := utils.PublicKeyFromString(*t.SenderPublicKey)
-		signature := utils.SignatureFromString(*t.Signature)
-		bc := bcs.GetBlockchain()
-		isUpdated := bc.AddTransaction(*t.SenderBlockchainAddress, *t.RecipientBlockchainAddress, *t.Value, publicKey, signature)
 		w.Header().Add("Content-Type", "application/json")
+		// A relayed transaction carries the hops it has left and the port
+		// of the neighbor that sent it; without them it goes no further.
+		ttl, err := queryInt(req, "ttl", 1)
+		var port int
+		if err == nil {
+			port, err = queryInt(req, "port", 0)
+		}
+		peer := ""
+		if err == nil && port != 0 {
+			peer, err = peerAddress(req, port)
+		}
+		if err == nil {
+			err = bcs.submitTransaction(&t, clampTTL(ttl), peer)
+		}
 		var m []byte
-		if !isUpdated {
+		if errors.Is(err, block.ErrDuplicateTransaction) {
+			m = utils.JsonStatus("known")
+		} else if err != nil {
+			log.Printf("ERROR: %v", err)
 			w.WriteHeader(http.StatusBadRequest)
-			m = utils.JsonStatus("fail")
+			m = utils.JsonError(err)
 		} else {
 			w.WriteHeader(http.StatusCreated)
 			m = utils.JsonStatus("success")
 		}
 		io.WriteString(w, string(m))
-	case http.MethodDelete:
-		bc := bcs.GetBlockchain()
-		bc.ClearTransactionPool()
-		io.WriteString(w, string(utils.JsonStatus("success")))
 	default:
 		log.Printf("ERROR: Invalid HTTP Method")	
 		w.WriteHeader(http.StatusBadRequest)	
@@ -164,16 +224,397 @@ func (bcs *BlockchainServer) StartMine(w http.ResponseWriter, req *http.Request)
 	}
 }
 
+func (bcs *BlockchainServer) Hashrate(w http.ResponseWriter, req *http.Request) {
+	switch req.Method {
+	case http.MethodGet:
+		bc := bcs.GetBlockchain()
+		if v := req.URL.Query().Get("measure"); v != "" {
+			seconds, err := strconv.ParseFloat(v, 64)
+			if err != nil || seconds <= 0 || seconds > block.MAX_HASHRATE_MEASURE_SEC {
+				w.WriteHeader(http.StatusBadRequest)
+				io.WriteString(w, string(utils.JsonStatus("fail")))
+				return
+			}
+			if _, err := bc.MeasureHashrate(time.Duration(seconds * float64(time.Second))); err != nil {
+				w.WriteHeader(http.StatusConflict)
+				io.WriteString(w, string(utils.JsonError(err)))
+				return
+			}
+		}
+		m, _ := json.Marshal(bc.MiningStats())
+		w.Header().Add("Content-Type", "application/json")
+		io.WriteString(w, string(m[:]))
+	default:
+		log.Println("ERROR: Invalid HTTP Method")
+		w.WriteHeader(http.StatusBadRequest)
+	}
+}
+
+// submitTransaction admits t, submitted here when peer is empty or relayed
+// by peer with ttl hops left, and gossips it on.
+func (bcs *BlockchainServer) submitTransaction(t *block.TransactionRequest, ttl int, peer string) error {
+	publicKey := utils.PublicKeyFromString(*t.SenderPublicKey)
+	signature := utils.SignatureFromString(*t.Signature)
+	bc := bcs.GetBlockchain()
+	fee, err := bc.ParseAmount(*t.Fee)
+	if err != nil {
+		return err
+	}
+	if t.Outputs != nil {
+		outputs, err := bc.ParseOutputs(t.Outputs)
+		if err != nil {
+			return err
+		}
+		return bc.ReceiveTransaction(block.NewUTXOTransaction(*t.SenderBlockchainAddress, t.Inputs, outputs, fee, publicKey, signature), ttl, peer)
+	}
+	value, err := bc.ParseAmount(*t.Value)
+	if err != nil {
+		return err
+	}
+	return bc.ReceiveTransaction(block.NewTransaction(*t.SenderBlockchainAddress, *t.RecipientBlockchainAddress, value, fee, *t.Nonce, publicKey, signature), ttl, peer)
+}
+
+// clampTTL bounds the hops a relayed transaction asks for by the hops this
+// node gives its own, so a peer cannot flood the network with it.
+func clampTTL(ttl int) int {
+	if ttl < 0 {
+		return 0
+	}
+	if ttl > block.TX_RELAY_TTL {
+		return block.TX_RELAY_TTL
+	}
+	return ttl
+}
+
+// peerAddress is the host:port a neighbor serves on: the host the request
+// came from and the port the neighbor sent along.
+func peerAddress(req *http.Request, port int) (string, error) {
+	host, _, err := net.SplitHostPort(req.RemoteAddr)
+	if err != nil {
+		return "", err
+	}
+	return net.JoinHostPort(host, strconv.Itoa(port)), nil
+}
+
 func (bcs *BlockchainServer) Amount (w http.ResponseWriter, req *http.Request) { 
 	switch req.Method{
 	case http.MethodGet:
 		blockchainAddress := req.URL.Query().Get("blockchain_address")
-		amount := bcs.GetBlockchain().CalculateTotalAmount(blockchainAddress)
+		bc := bcs.GetBlockchain()
+		amount, err := bc.CalculateTotalAmount(blockchainAddress)
+		w.Header().Add("Content-Type", "application/json")
+		if err != nil {
+			log.Printf("ERROR: %v", err)
+			w.WriteHeader(http.StatusInternalServerError)
+			io.WriteString(w, string(utils.JsonError(err)))
+			return
+		}
 
-		ar := &block.AmountResponse{amount}
+		ar := &block.AmountResponse{Amount: bc.FormatAmount(amount)}
 		m, _ := ar.MarshalJSON()
+		io.WriteString(w, string(m[:]))
+	}
+}
+
+func (bcs *BlockchainServer) UTXOs(w http.ResponseWriter, req *http.Request) {
+	switch req.Method {
+	case http.MethodGet:
+		blockchainAddress := req.URL.Query().Get("blockchain_address")
+		bc := bcs.GetBlockchain()
+		params := bc.Params()
+		ur := &block.UTXOResponse{Ledger: params.Ledger, UTXOs: bc.UnspentOutputs(blockchainAddress), Decimals: params.Decimals}
+		m, _ := ur.MarshalJSON()
 		w.Header().Add("Content-Type", "application/json")
 		io.WriteString(w, string(m[:]))
+	default:
+		log.Printf("ERROR: Invalid HTTP Method")
+		w.WriteHeader(http.StatusBadRequest)
+	}
+}
+
+func (bcs *BlockchainServer) Nonce(w http.ResponseWriter, req *http.Request) {
+	switch req.Method {
+	case http.MethodGet:
+		blockchainAddress := req.URL.Query().Get("blockchain_address")
+		nr := &block.NonceResponse{Nonce: bcs.GetBlockchain().NextNonce(blockchainAddress)}
+		m, _ := nr.MarshalJSON()
+		w.Header().Add("Content-Type", "application/json")
+		io.WriteString(w, string(m[:]))
+	default:
+		log.Printf("ERROR: Invalid HTTP Method")
+		w.WriteHeader(http.StatusBadRequest)
+	}
+}
+
+func (bcs *BlockchainServer) MerkleProof(w http.ResponseWriter, req *http.Request) {
+	switch req.Method {
+	case http.MethodGet:
+		w.Header().Add("Content-Type", "application/json")
+		txID, err := block.DecodeHash(req.URL.Query().Get("tx_id"))
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			io.WriteString(w, string(utils.JsonError(err)))
+			return
+		}
+		proof, err := bcs.GetBlockchain().MerkleProof(txID)
+		if err != nil {
+			w.WriteHeader(http.StatusNotFound)
+			io.WriteString(w, string(utils.JsonError(err)))
+			return
+		}
+		m, _ := proof.MarshalJSON()
+		io.WriteString(w, string(m[:]))
+	default:
+		log.Printf("ERROR: Invalid HTTP Method")
+		w.WriteHeader(http.StatusBadRequest)
+	}
+}
+
+func (bcs *BlockchainServer) Tip(w http.ResponseWriter, req *http.Request) {
+	switch req.Method {
+	case http.MethodGet:
+		m, _ := bcs.GetBlockchain().Tip().MarshalJSON()
+		w.Header().Add("Content-Type", "application/json")
+		io.WriteString(w, string(m[:]))
+	default:
+		log.Printf("ERROR: Invalid HTTP Method")
+		w.WriteHeader(http.StatusBadRequest)
+	}
+}
+
+func (bcs *BlockchainServer) Supply(w http.ResponseWriter, req *http.Request) {
+	switch req.Method {
+	case http.MethodGet:
+		w.Header().Add("Content-Type", "application/json")
+		sr, err := bcs.GetBlockchain().Supply()
+		if err != nil {
+			log.Printf("ERROR: %v", err)
+			w.WriteHeader(http.StatusInternalServerError)
+			io.WriteString(w, string(utils.JsonError(err)))
+			return
+		}
+		m, _ := sr.MarshalJSON()
+		io.WriteString(w, string(m[:]))
+	default:
+		log.Printf("ERROR: Invalid HTTP Method")
+		w.WriteHeader(http.StatusBadRequest)
+	}
+}
+
+// StateCheck rebuilds the state from the stored blocks and reports where
+// the running node's index disagrees with it.
+func (bcs *BlockchainServer) StateCheck(w http.ResponseWriter, req *http.Request) {
+	switch req.Method {
+	case http.MethodGet:
+		w.Header().Add("Content-Type", "application/json")
+		sc, err := bcs.GetBlockchain().CheckState()
+		if err != nil {
+			log.Printf("ERROR: %v", err)
+			w.WriteHeader(http.StatusInternalServerError)
+			io.WriteString(w, string(utils.JsonError(err)))
+			return
+		}
+		if !sc.Consistent() {
+			log.Printf("action=check_state, status=inconsistent, mismatches=%d", len(sc.Mismatches))
+		}
+		m, _ := sc.MarshalJSON()
+		io.WriteString(w, string(m[:]))
+	default:
+		log.Printf("ERROR: Invalid HTTP Method")
+		w.WriteHeader(http.StatusBadRequest)
+	}
+}
+
+// CheckState asks the node serving on the server's port for /state/check,
+// which compares the state index it has kept up to date while running with
+// one rebuilt from its stored blocks, and prints the report. It reports
+// whether the state is consistent.
+func (bcs *BlockchainServer) CheckState() bool {
+	resp, err := http.Get(fmt.Sprintf("http://127.0.0.1:%d/state/check", bcs.port))
+	if err != nil {
+		log.Printf("ERROR: %v", err)
+		return false
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		log.Printf("ERROR: %v", err)
+		return false
+	}
+	var report struct {
+		Consistent bool `json:"consistent"`
+	}
+	if err := json.Unmarshal(body, &report); err != nil {
+		log.Printf("ERROR: state check: %s: %v", resp.Status, err)
+		return false
+	}
+	var out bytes.Buffer
+	if json.Indent(&out, body, "", "  ") != nil {
+		out.Reset()
+		out.Write(body)
+	}
+	fmt.Println(out.String())
+	return report.Consistent
+}
+
+// queryInt reads an integer query parameter, falling back to def when it is
+// absent.
+func queryInt(req *http.Request, key string, def int) (int, error) {
+	v := req.URL.Query().Get(key)
+	if v == "" {
+		return def, nil
+	}
+	return strconv.Atoi(v)
+}
+
+func (bcs *BlockchainServer) Headers(w http.ResponseWriter, req *http.Request) {
+	switch req.Method {
+	case http.MethodGet:
+		w.Header().Add("Content-Type", "application/json")
+		bc := bcs.GetBlockchain()
+		count, err := queryInt(req, "count", block.MAX_HEADERS_PER_REQUEST)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			io.WriteString(w, string(utils.JsonError(err)))
+			return
+		}
+		var headers []*block.BlockHeader
+		if req.URL.Query().Has("locator") {
+			locator, err := block.DecodeLocator(req.URL.Query().Get("locator"))
+			if err != nil {
+				w.WriteHeader(http.StatusBadRequest)
+				io.WriteString(w, string(utils.JsonError(err)))
+				return
+			}
+			headers = bc.HeadersAfter(locator, count)
+		} else {
+			start, err := queryInt(req, "start", 0)
+			if err != nil {
+				w.WriteHeader(http.StatusBadRequest)
+				io.WriteString(w, string(utils.JsonError(err)))
+				return
+			}
+			headers = bc.Headers(start, count)
+		}
+		m, _ := json.Marshal(&block.HeadersResponse{Headers: headers})
+		io.WriteString(w, string(m[:]))
+	default:
+		log.Printf("ERROR: Invalid HTTP Method")
+		w.WriteHeader(http.StatusBadRequest)
+	}
+}
+
+func (bcs *BlockchainServer) Blocks(w http.ResponseWriter, req *http.Request) {
+	switch req.Method {
+	case http.MethodGet:
+		w.Header().Add("Content-Type", "application/json")
+		bc := bcs.GetBlockchain()
+		count, err := queryInt(req, "count", block.MAX_BLOCKS_PER_REQUEST)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			io.WriteString(w, string(utils.JsonError(err)))
+			return
+		}
+		var blocks []*block.Block
+		if h := req.URL.Query().Get("hash"); h != "" {
+			hash, err := block.DecodeHash(h)
+			var b *block.Block
+			if err == nil {
+				b, err = bc.BlockByHash(hash)
+			}
+			if err != nil {
+				w.WriteHeader(http.StatusNotFound)
+				io.WriteString(w, string(utils.JsonError(err)))
+				return
+			}
+			blocks = []*block.Block{b}
+		} else if after := req.URL.Query().Get("after"); after != "" {
+			hash, err := block.DecodeHash(after)
+			if err == nil {
+				blocks, err = bc.BlocksAfter(hash, count)
+			}
+			if err != nil {
+				w.WriteHeader(http.StatusNotFound)
+				io.WriteString(w, string(utils.JsonError(err)))
+				return
+			}
+		} else {
+			start, err := queryInt(req, "start", 0)
+			if err != nil {
+				w.WriteHeader(http.StatusBadRequest)
+				io.WriteString(w, string(utils.JsonError(err)))
+				return
+			}
+			blocks = bc.Blocks(start, count)
+		}
+		m, _ := json.Marshal(&block.BlocksResponse{Blocks: blocks})
+		io.WriteString(w, string(m[:]))
+	case http.MethodPost:
+		w.Header().Add("Content-Type", "application/json")
+		var b block.Block
+		if err := json.NewDecoder(req.Body).Decode(&b); err != nil {
+			log.Printf("ERROR: %v", err)
+			w.WriteHeader(http.StatusBadRequest)
+			io.WriteString(w, string(utils.JsonError(err)))
+			return
+		}
+		// A sender that serves blocks names its port, so missing parents
+		// can be asked for at the address the block came from.
+		port, err := queryInt(req, "port", 0)
+		peer := ""
+		if err == nil && port != 0 {
+			peer, err = peerAddress(req, port)
+		}
+		if err == nil {
+			err = bcs.GetBlockchain().ReceiveBlock(&b, peer)
+		}
+		switch {
+		case err == nil:
+			w.WriteHeader(http.StatusCreated)
+			io.WriteString(w, string(utils.JsonStatus("success")))
+		case errors.Is(err, block.ErrKnownBlock):
+			io.WriteString(w, string(utils.JsonStatus("known")))
+		case errors.Is(err, block.ErrOrphanBlock):
+			w.WriteHeader(http.StatusAccepted)
+			io.WriteString(w, string(utils.JsonStatus("orphan")))
+		case errors.Is(err, block.ErrSideBranch):
+			w.WriteHeader(http.StatusAccepted)
+			io.WriteString(w, string(utils.JsonStatus("side_branch")))
+		default:
+			log.Printf("ERROR: %v", err)
+			w.WriteHeader(http.StatusBadRequest)
+			io.WriteString(w, string(utils.JsonError(err)))
+		}
+	default:
+		log.Printf("ERROR: Invalid HTTP Method")
+		w.WriteHeader(http.StatusBadRequest)
+	}
+}
+
+func (bcs *BlockchainServer) BlockInventory(w http.ResponseWriter, req *http.Request) {
+	switch req.Method {
+	case http.MethodPost:
+		w.Header().Add("Content-Type", "application/json")
+		var inv block.InventoryRequest
+		if err := json.NewDecoder(req.Body).Decode(&inv); err != nil {
+			log.Printf("ERROR: %v", err)
+			w.WriteHeader(http.StatusBadRequest)
+			io.WriteString(w, string(utils.JsonError(err)))
+			return
+		}
+		peer, err := peerAddress(req, int(inv.Port))
+		if err != nil {
+			log.Printf("ERROR: %v", err)
+			w.WriteHeader(http.StatusBadRequest)
+			io.WriteString(w, string(utils.JsonError(err)))
+			return
+		}
+		bcs.GetBlockchain().ReceiveInventory(inv.Hashes, peer)
+		w.WriteHeader(http.StatusAccepted)
+		io.WriteString(w, string(utils.JsonStatus("success")))
+	default:
+		log.Printf("ERROR: Invalid HTTP Method")
+		w.WriteHeader(http.StatusBadRequest)
 	}
 }
 
@@ -200,7 +641,17 @@ func (bcs *BlockchainServer) Run() {
 	http.HandleFunc("/transactions", bcs.Transactions)
 	http.HandleFunc("/mine", bcs.Mine)
 	http.HandleFunc("/mine/start", bcs.StartMine)
+	http.HandleFunc("/mine/hashrate", bcs.Hashrate)
 	http.HandleFunc("/amount", bcs.Amount)
+	http.HandleFunc("/nonce", bcs.Nonce)
+	http.HandleFunc("/utxos", bcs.UTXOs)
+	http.HandleFunc("/supply", bcs.Supply)
+	http.HandleFunc("/state/check", bcs.StateCheck)
+	http.HandleFunc("/merkle_proof", bcs.MerkleProof)
+	http.HandleFunc("/tip", bcs.Tip)
+	http.HandleFunc("/headers", bcs.Headers)
+	http.HandleFunc("/blocks", bcs.Blocks)
+	http.HandleFunc("/blocks/inv", bcs.BlockInventory)
 	http.HandleFunc("/consensus", bcs.Consensus)
 	log.Fatal(http.ListenAndServe("0.0.0.0:"+strconv.Itoa(int(bcs.port)), nil))
 }
\ No newline at end of file
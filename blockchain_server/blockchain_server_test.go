@@ -0,0 +1,73 @@
+package main
+
+import (
+	"goblockchain/block"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestPeerAddress(t *testing.T) {
+	req := httptest.NewRequest("POST", "/blocks?port=5002&peer=evil.example:80", nil)
+	req.RemoteAddr = "10.0.0.7:41234"
+	peer, err := peerAddress(req, 5002)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if peer != "10.0.0.7:5002" {
+		t.Fatalf("peer %s, want 10.0.0.7:5002", peer)
+	}
+}
+
+func TestTransactionsRejectsMalformedKey(t *testing.T) {
+	bcs := NewBlockchainServer(5001, t.TempDir(), block.DefaultChainParams(), 1, 0, 1)
+	body := `{"sender_blockchain_address":"1BvBMSEYstWetqTFn5Au4m4GFg7xJaNVN2",` +
+		`"recipient_blockchain_address":"1BvBMSEYstWetqTFn5Au4m4GFg7xJaNVN2",` +
+		`"sender_public_key":"ab","value":"1","fee":"0","nonce":0,"signature":"cd"}`
+	for _, method := range []string{"POST", "PUT"} {
+		w := httptest.NewRecorder()
+		bcs.Transactions(w, httptest.NewRequest(method, "/transactions", strings.NewReader(body)))
+		if w.Code != http.StatusBadRequest {
+			t.Fatalf("%s with a short key: status %d, want %d", method, w.Code, http.StatusBadRequest)
+		}
+	}
+}
+
+func TestClampTTL(t *testing.T) {
+	for ttl, want := range map[int]int{-5: 0, 0: 0, 3: 3, block.TX_RELAY_TTL: block.TX_RELAY_TTL, 1000000: block.TX_RELAY_TTL} {
+		if got := clampTTL(ttl); got != want {
+			t.Fatalf("clampTTL(%d) = %d, want %d", ttl, got, want)
+		}
+	}
+}
+
+func TestCheckStateReadsRunningNode(t *testing.T) {
+	consistent := true
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.URL.Path != "/state/check" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		sc := &block.StateCheckResponse{}
+		if !consistent {
+			sc.Mismatches = []string{"balance of 1BvBMSEYstWetqTFn5Au4m4GFg7xJaNVN2: index 1, rebuilt 2"}
+		}
+		m, _ := sc.MarshalJSON()
+		w.Write(m)
+	}))
+	defer srv.Close()
+	_, portStr, _ := net.SplitHostPort(strings.TrimPrefix(srv.URL, "http://"))
+	port, _ := strconv.Atoi(portStr)
+	bcs := NewBlockchainServer(uint16(port), t.TempDir(), block.DefaultChainParams(), 1, 0, 1)
+
+	if !bcs.CheckState() {
+		t.Fatal("consistent node reported inconsistent")
+	}
+	consistent = false
+	if bcs.CheckState() {
+		t.Fatal("inconsistent node reported consistent")
+	}
+}
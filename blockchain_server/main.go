@@ -2,7 +2,9 @@ package main
 
 import (
 	"flag"
+	"goblockchain/block"
 	"log"
+	"os"
 )
 
 func init() {
@@ -11,8 +13,34 @@ func init() {
 
 func main() {
 	port := flag.Uint("port", 5001, "TCP Port Number for Blockchain Server")
+	dataDir := flag.String("datadir", "chaindata", "Directory for the Blockchain Server's chain data")
+	miners := flag.Int("miners", 0, "Number of proof of work goroutines (0 uses every CPU)")
+	minRelayFee := flag.Uint64("minrelayfee", block.DEFAULT_MIN_RELAY_FEE_RATE, "Minimum fee in base units per transaction byte")
+	mempoolSize := flag.Int("mempool", block.MEMPOOL_MAX_SIZE, "Maximum number of pending transactions")
+	genesis := flag.String("genesis", "", "Chain params file with the genesis block of the network (default built-in params)")
+	ledger := flag.String("ledger", block.LEDGER_ACCOUNT, "Ledger of the built-in params: account or utxo (not with -genesis, whose file sets the ledger)")
+	checkState := flag.Bool("checkstate", false, "Ask the node running on -port to compare its live state index with one rebuilt from its blocks, print the report and exit")
 	flag.Parse()
-	app := NewBlockchainServer(uint16(*port))
+	params := block.DefaultChainParams()
+	params.Ledger = *ledger
+	if *genesis != "" {
+		flag.Visit(func(f *flag.Flag) {
+			if f.Name == "ledger" {
+				log.Fatalf("ERROR: -ledger only applies to the built-in params; set \"ledger\" in %s instead", *genesis)
+			}
+		})
+		var err error
+		if params, err = block.LoadChainParams(*genesis); err != nil {
+			log.Fatalf("ERROR: %v", err)
+		}
+	}
+	app := NewBlockchainServer(uint16(*port), *dataDir, params, *miners, *minRelayFee, *mempoolSize)
+	if *checkState {
+		if !app.CheckState() {
+			os.Exit(1)
+		}
+		return
+	}
 	log.Print("Server starts, port ", *port)
 	app.Run()
 }
\ No newline at end of file
@@ -0,0 +1,112 @@
+package utils
+
+import (
+	"errors"
+	"math/bits"
+	"strconv"
+	"strings"
+)
+
+// Amount is a quantity of coins in indivisible base units.
+type Amount uint64
+
+var (
+	ErrAmountOverflow  = errors.New("amount overflows")
+	ErrAmountUnderflow = errors.New("amount underflows")
+	ErrAmountSyntax    = errors.New("invalid amount")
+	ErrAmountPrecision = errors.New("amount has too many decimal places")
+)
+
+func AddAmount(a Amount, b Amount) (Amount, error) {
+	sum, carry := bits.Add64(uint64(a), uint64(b), 0)
+	if carry != 0 {
+		return 0, ErrAmountOverflow
+	}
+	return Amount(sum), nil
+}
+
+func SubAmount(a Amount, b Amount) (Amount, error) {
+	if b > a {
+		return 0, ErrAmountUnderflow
+	}
+	return a - b, nil
+}
+
+func pow10(decimals uint8) (uint64, error) {
+	p := uint64(1)
+	for i := uint8(0); i < decimals; i++ {
+		hi, lo := bits.Mul64(p, 10)
+		if hi != 0 {
+			return 0, ErrAmountOverflow
+		}
+		p = lo
+	}
+	return p, nil
+}
+
+// ParseAmount reads a non-negative decimal string such as "12.5" into base
+// units with the given number of decimal places, without rounding. The
+// whole part is required and has no leading zeroes, so ".5" and "01" are
+// refused like "1." is; zeroes trailing the fraction are ignored.
+func ParseAmount(s string, decimals uint8) (Amount, error) {
+	whole, frac, hasPoint := strings.Cut(s, ".")
+	if whole == "" || hasPoint && frac == "" || len(whole) > 1 && whole[0] == '0' {
+		return 0, ErrAmountSyntax
+	}
+	if len(frac) > int(decimals) {
+		frac = strings.TrimRight(frac, "0")
+		if len(frac) > int(decimals) {
+			return 0, ErrAmountPrecision
+		}
+	}
+	for _, part := range []string{whole, frac} {
+		for _, c := range part {
+			if c < '0' || c > '9' {
+				return 0, ErrAmountSyntax
+			}
+		}
+	}
+	unit, err := pow10(decimals)
+	if err != nil {
+		return 0, err
+	}
+	var w uint64
+	if whole != "" {
+		if w, err = strconv.ParseUint(whole, 10, 64); err != nil {
+			return 0, ErrAmountOverflow
+		}
+	}
+	hi, units := bits.Mul64(w, unit)
+	if hi != 0 {
+		return 0, ErrAmountOverflow
+	}
+	var f uint64
+	if frac != "" {
+		frac += strings.Repeat("0", int(decimals)-len(frac))
+		if f, err = strconv.ParseUint(frac, 10, 64); err != nil {
+			return 0, ErrAmountOverflow
+		}
+	}
+	return AddAmount(Amount(units), Amount(f))
+}
+
+// FormatAmount writes a in decimal with the given number of decimal places,
+// dropping trailing zeroes of the fraction.
+func FormatAmount(a Amount, decimals uint8) string {
+	s := strconv.FormatUint(uint64(a), 10)
+	if decimals == 0 {
+		return s
+	}
+	if len(s) <= int(decimals) {
+		s = strings.Repeat("0", int(decimals)-len(s)+1) + s
+	}
+	whole, frac := s[:len(s)-int(decimals)], strings.TrimRight(s[len(s)-int(decimals):], "0")
+	if frac == "" {
+		return whole
+	}
+	return whole + "." + frac
+}
+
+// MaxAmountDecimals is the largest number of decimal places for which a
+// single whole coin still fits in an Amount.
+const MaxAmountDecimals = 19
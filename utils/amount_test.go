@@ -0,0 +1,82 @@
+package utils
+
+import (
+	"errors"
+	"math"
+	"testing"
+)
+
+func TestParseAmount(t *testing.T) {
+	tests := []struct {
+		s        string
+		decimals uint8
+		want     Amount
+		err      error
+	}{
+		{"0", 8, 0, nil},
+		{"1", 8, 100000000, nil},
+		{"12.5", 8, 1250000000, nil},
+		{"0.00000001", 8, 1, nil},
+		{"1.50", 2, 150, nil},
+		{"7", 0, 7, nil},
+
+		// Amounts are never rounded: extra places must be zero.
+		{"0.000000010", 8, 1, nil},
+		{"0.000000015", 8, 0, ErrAmountPrecision},
+		{"0.5", 0, 0, ErrAmountPrecision},
+		{"1.0", 0, 1, nil},
+
+		{"18446744073709551615", 0, math.MaxUint64, nil},
+		{"18446744073709551616", 0, 0, ErrAmountOverflow},
+		{"184467440737.09551615", 8, math.MaxUint64, nil},
+		{"184467440737.09551616", 8, 0, ErrAmountOverflow},
+		{"184467440738", 8, 0, ErrAmountOverflow},
+		{"99999999999999999999999", 8, 0, ErrAmountOverflow},
+		{"1", 20, 0, ErrAmountOverflow},
+
+		{"", 8, 0, ErrAmountSyntax},
+		{".", 8, 0, ErrAmountSyntax},
+		{".5", 8, 0, ErrAmountSyntax},
+		{"1.", 8, 0, ErrAmountSyntax},
+		{"01", 8, 0, ErrAmountSyntax},
+		{"00.5", 8, 0, ErrAmountSyntax},
+		{"-1", 8, 0, ErrAmountSyntax},
+		{"+1", 8, 0, ErrAmountSyntax},
+		{"1e3", 8, 0, ErrAmountSyntax},
+		{"1.2.3", 8, 0, ErrAmountSyntax},
+		{" 1", 8, 0, ErrAmountSyntax},
+		{"1,5", 8, 0, ErrAmountSyntax},
+	}
+	for _, tt := range tests {
+		got, err := ParseAmount(tt.s, tt.decimals)
+		if !errors.Is(err, tt.err) || got != tt.want {
+			t.Errorf("ParseAmount(%q, %d) = %d, %v; want %d, %v", tt.s, tt.decimals, got, err, tt.want, tt.err)
+		}
+	}
+}
+
+func TestFormatAmount(t *testing.T) {
+	tests := []struct {
+		a        Amount
+		decimals uint8
+		want     string
+	}{
+		{0, 8, "0"},
+		{1, 8, "0.00000001"},
+		{100000000, 8, "1"},
+		{1250000000, 8, "12.5"},
+		{150, 2, "1.5"},
+		{7, 0, "7"},
+		{math.MaxUint64, 8, "184467440737.09551615"},
+		{math.MaxUint64, MaxAmountDecimals, "1.8446744073709551615"},
+	}
+	for _, tt := range tests {
+		got := FormatAmount(tt.a, tt.decimals)
+		if got != tt.want {
+			t.Errorf("FormatAmount(%d, %d) = %q, want %q", tt.a, tt.decimals, got, tt.want)
+		}
+		if back, err := ParseAmount(got, tt.decimals); err != nil || back != tt.a {
+			t.Errorf("ParseAmount(%q, %d) = %d, %v; want %d", got, tt.decimals, back, err, tt.a)
+		}
+	}
+}
@@ -37,12 +37,16 @@ func SignatureFromString(s string) *Signature {
 
 func PublicKeyFromString(s string) *ecdsa.PublicKey {
 	x, y := String2BigIntTuple(s)
-	return &ecdsa.PublicKey{elliptic.P256(), &x, &y}
+	return &ecdsa.PublicKey{Curve: elliptic.P256(), X: &x, Y: &y}
+}
+
+func PublicKeyString(publicKey *ecdsa.PublicKey) string {
+	return fmt.Sprintf("%064x%064x", publicKey.X, publicKey.Y)
 }
 
 func PrivateKeyFromString(s string, publicKey *ecdsa.PublicKey) *ecdsa.PrivateKey {
 	b, _ := hex.DecodeString(s[:])
 	var bi big.Int
 	_ = bi.SetBytes(b)
-	return &ecdsa.PrivateKey{*publicKey, &bi}
+	return &ecdsa.PrivateKey{PublicKey: *publicKey, D: &bi}
 }
\ No newline at end of file
@@ -0,0 +1,69 @@
+package utils
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"testing"
+)
+
+func TestEncoder(t *testing.T) {
+	var txID [32]byte
+	txID[31] = 0xff
+	tests := []struct {
+		name string
+		put  func(e *Encoder)
+		want string
+	}{
+		{"uint8", func(e *Encoder) { e.PutUint8(0xab) }, "ab"},
+		{"bool", func(e *Encoder) { e.PutBool(true); e.PutBool(false) }, "0100"},
+		{"uint32", func(e *Encoder) { e.PutUint32(0x01020304) }, "01020304"},
+		{"uint64", func(e *Encoder) { e.PutUint64(0x0102030405060708) }, "0102030405060708"},
+		{"amount", func(e *Encoder) { e.PutAmount(1) }, "0000000000000001"},
+		{"fixed", func(e *Encoder) { e.PutFixed([]byte{1, 2}) }, "0102"},
+		{"bytes", func(e *Encoder) { e.PutBytes([]byte{1, 2}) }, "000000020102"},
+		{"empty string", func(e *Encoder) { e.PutString("") }, "00000000"},
+		{"string", func(e *Encoder) { e.PutString("ab") }, "000000026162"},
+		{"outpoint", func(e *Encoder) { e.PutOutPoint(OutPoint{TxID: txID, Index: 2}) },
+			"00000000000000000000000000000000000000000000000000000000000000ff00000002"},
+		{"output", func(e *Encoder) { e.PutTxOutput(TxOutput{Address: "a", Value: 3}) }, "00000001610000000000000003"},
+	}
+	for _, tt := range tests {
+		e := NewEncoder()
+		tt.put(e)
+		if got := hex.EncodeToString(e.Bytes()); got != tt.want {
+			t.Errorf("%s: got %s, want %s", tt.name, got, tt.want)
+		}
+	}
+}
+
+// TestEncodeTransactionFieldBoundaries checks that the length prefixes
+// keep adjacent fields apart: moving characters between sender and
+// recipient must change the encoding.
+func TestEncodeTransactionFieldBoundaries(t *testing.T) {
+	a := EncodeTransaction("ab", "c", 1, 0, 0, false, nil, nil)
+	b := EncodeTransaction("a", "bc", 1, 0, 0, false, nil, nil)
+	if bytes.Equal(a, b) {
+		t.Fatal("sender and recipient boundary is not encoded")
+	}
+	if a[0] != TX_ENCODING_VERSION {
+		t.Fatalf("encoding starts with %d, want version %d", a[0], TX_ENCODING_VERSION)
+	}
+	noInputs := EncodeTransaction("a", "", 0, 0, 0, false, nil, []TxOutput{{Address: "b", Value: 1}})
+	noOutputs := EncodeTransaction("a", "", 0, 0, 0, false, []OutPoint{{Index: 1}}, nil)
+	if bytes.Equal(noInputs, noOutputs) {
+		t.Fatal("inputs and outputs are not told apart")
+	}
+}
+
+func TestTransactionHash(t *testing.T) {
+	inputs := []OutPoint{{Index: 1}}
+	outputs := []TxOutput{{Address: "b", Value: 1}}
+	want := sha256.Sum256(EncodeTransaction("a", "", 0, 5, 0, false, inputs, outputs))
+	if got := TransactionHash("a", "", 0, 5, 0, false, inputs, outputs); got != want {
+		t.Fatalf("got %x, want %x", got, want)
+	}
+	if TransactionHash("a", "", 0, 5, 0, true, inputs, outputs) == want {
+		t.Fatal("coinbase flag does not change the hash")
+	}
+}
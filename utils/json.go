@@ -15,6 +15,17 @@ func JsonStatus (message string) []byte {
 	return m
 }
 
+func JsonError(err error) []byte {
+	m, _ := json.Marshal(struct{
+		Message string `json:"message"`
+		Error   string `json:"error"`
+	}{
+		Message: "fail",
+		Error:   err.Error(),
+	})
+	return m
+}
+
 func ParseBody(r *http.Request, x interface{}) {
 	if body, err := ioutil.ReadAll(r.Body); err == nil {
 		if err := json.Unmarshal([]byte(body), x); err != nil {
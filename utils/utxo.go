@@ -0,0 +1,61 @@
+package utils
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+// OutPoint names an output by the ID of the transaction that created it
+// and its position among that transaction's outputs.
+type OutPoint struct {
+	TxID  [32]byte
+	Index uint32
+}
+
+func (o OutPoint) String() string {
+	return fmt.Sprintf("%x:%d", o.TxID, o.Index)
+}
+
+func (o OutPoint) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		TxID  string `json:"tx_id"`
+		Index uint32 `json:"index"`
+	}{
+		TxID:  hex.EncodeToString(o.TxID[:]),
+		Index: o.Index,
+	})
+}
+
+func (o *OutPoint) UnmarshalJSON(data []byte) error {
+	var txID string
+	v := struct {
+		TxID  *string `json:"tx_id"`
+		Index *uint32 `json:"index"`
+	}{
+		TxID:  &txID,
+		Index: &o.Index,
+	}
+	if err := json.Unmarshal(data, &v); err != nil {
+		return err
+	}
+	b, err := hex.DecodeString(txID)
+	if err != nil || len(b) != 32 {
+		return errors.New("invalid tx_id")
+	}
+	copy(o.TxID[:], b)
+	return nil
+}
+
+// TxOutput pays Value base units to Address.
+type TxOutput struct {
+	Address string `json:"address"`
+	Value   Amount `json:"value"`
+}
+
+// UnspentOutput is an output together with where it can be spent from.
+type UnspentOutput struct {
+	OutPoint OutPoint
+	Output   TxOutput
+}
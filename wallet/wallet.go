@@ -1,15 +1,19 @@
 package wallet
 
 import (
+	"bytes"
 	"crypto/ecdsa"
 	"crypto/elliptic"
 	"crypto/rand"
 	"crypto/sha256"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"goblockchain/utils"
 	"github.com/btcsuite/btcutil/base58"
 	"golang.org/x/crypto/ripemd160"
+	"math/big"
+	"sort"
 )
 
 
@@ -25,10 +29,32 @@ func NewWallet() *Wallet {
 	privateKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
 	w.privateKey = privateKey
 	w.publicKey = &w.privateKey.PublicKey
+	w.blockChainAddress = AddressFromPublicKey(w.publicKey)
+	return w
+}
+
+// NewWalletFromPrivateKey restores the wallet whose private key
+// PrivateKeyStr printed as privateKeyStr.
+func NewWalletFromPrivateKey(privateKeyStr string) (*Wallet, error) {
+	curve := elliptic.P256()
+	d, ok := new(big.Int).SetString(privateKeyStr, 16)
+	if !ok || d.Sign() <= 0 || d.Cmp(curve.Params().N) >= 0 {
+		return nil, errors.New("invalid private key")
+	}
+	w := new(Wallet)
+	x, y := curve.ScalarBaseMult(d.Bytes())
+	w.privateKey = &ecdsa.PrivateKey{PublicKey: ecdsa.PublicKey{Curve: curve, X: x, Y: y}, D: d}
+	w.publicKey = &w.privateKey.PublicKey
+	w.blockChainAddress = AddressFromPublicKey(w.publicKey)
+	return w, nil
+}
+
+// AddressFromPublicKey derives the base58 blockchain address of publicKey.
+func AddressFromPublicKey(publicKey *ecdsa.PublicKey) string {
 	// 2. Perform SHA-256 hashing on publicKey
 	h2 := sha256.New()
-	h2.Write(w.publicKey.X.Bytes())
-	h2.Write(w.publicKey.Y.Bytes())
+	h2.Write(publicKey.X.Bytes())
+	h2.Write(publicKey.Y.Bytes())
 	digest2 := h2.Sum(nil) 
 	// 3. Perform RIPEMD-160 hashing on the result of SHA-256 (20 bytes)
 	h3 := ripemd160.New()
@@ -38,24 +64,56 @@ func NewWallet() *Wallet {
 	vd4 := make([]byte, 21)
 	vd4[0] = 0x00
 	copy(vd4[1:], digest3[:])
+	// 5-7. Checksum of the extended RIPEMD-160 result
+	chsum := addressChecksum(vd4)
+	// 8. Add the 4 checksum bytes from 7 at the end of extended RIPEMD-160 hash from step 4 (25 bytes)
+	dc8 := make([]byte, 25)
+	copy(dc8[:21], vd4[:])
+	copy(dc8[21:], chsum[:])
+	// 9. Convert the result from a byte string to base58
+	return base58.Encode(dc8)
+}
+
+func addressChecksum(versioned []byte) []byte {
 	// 5. Perform SHA-256 hash on the extended RIPEMD-160 result
 	h5 := sha256.New()
-	h5.Write(vd4)
+	h5.Write(versioned)
 	digest5 := h5.Sum(nil)
 	// 6. Perform SHA-256 hash on the result of the previous SHA-256 hash
 	h6 := sha256.New()
 	h6.Write(digest5)
 	digest6 := h6.Sum(nil)
 	// 7. Take the first 4 bytes of the second SHA-256 for checksum
-	chsum := digest6[:4]
-	// 8. Add the 4 checksum bytes from 7 at the end of extended RIPEMD-160 hash from step 4 (25 bytes)
-	dc8 := make([]byte, 25)
-	copy(dc8[:21], vd4[:])
-	copy(dc8[21:], chsum[:])
-	// 9. Convert the result from a byte string to base58
-	address := base58.Encode(dc8)
-	w.blockChainAddress = address
-	return w
+	return digest6[:4]
+}
+
+var (
+	ErrAddressEncoding = errors.New("address is not base58")
+	ErrAddressLength   = errors.New("address has wrong length")
+	ErrAddressVersion  = errors.New("address has unknown version byte")
+	ErrAddressChecksum = errors.New("address checksum mismatch")
+)
+
+// ValidateAddress checks that address has the shape AddressFromPublicKey
+// produces: 25 base58 bytes with a version byte and a matching checksum.
+func ValidateAddress(address string) error {
+	if address == "" {
+		return ErrAddressEncoding
+	}
+	decoded := base58.Decode(address)
+	if len(decoded) == 0 {
+		return ErrAddressEncoding
+	}
+	if len(decoded) != 25 {
+		return ErrAddressLength
+	}
+	if decoded[0] != 0x00 {
+		return ErrAddressVersion
+	}
+	if !bytes.Equal(addressChecksum(decoded[:21]), decoded[21:]) {
+		return ErrAddressChecksum
+	}
+	return nil
 }
 
 func (w *Wallet) PrivateKey() *ecdsa.PrivateKey {
@@ -71,7 +129,7 @@ func (w *Wallet) PublicKey() *ecdsa.PublicKey {
 }
 
 func (w *Wallet) PublicKeyStr() string {
-	return fmt.Sprintf("%x%x", w.publicKey.X.Bytes(), w.publicKey.Y.Bytes())
+	return utils.PublicKeyString(w.publicKey)
 }
 
 func (w *Wallet) BlockChainAddress() string {
@@ -95,30 +153,88 @@ type Transaction struct {
 	senderPublicKey  		   *ecdsa.PublicKey
 	senderBlockChainAddress    string
 	recipientBlockchainAddress string
-	value 					   float32
+	value 					   utils.Amount
+	fee                        utils.Amount
+	nonce                      uint64
+	inputs                     []utils.OutPoint
+	outputs                    []utils.TxOutput
 }
 
 func NewTransaction(privateKey *ecdsa.PrivateKey, publicKey *ecdsa.PublicKey,
-	 sender string, recipient string, value float32) *Transaction {
-		return &Transaction{privateKey, publicKey, sender, recipient, value}
+	 sender string, recipient string, value utils.Amount, fee utils.Amount, nonce uint64) *Transaction {
+		return &Transaction{privateKey, publicKey, sender, recipient, value, fee, nonce, nil, nil}
+}
+
+// NewUTXOTransaction spends inputs of sender into outputs on a chain using
+// the UTXO ledger; see SelectOutputs.
+func NewUTXOTransaction(privateKey *ecdsa.PrivateKey, publicKey *ecdsa.PublicKey,
+	sender string, inputs []utils.OutPoint, outputs []utils.TxOutput, fee utils.Amount) *Transaction {
+	return &Transaction{privateKey, publicKey, sender, "", 0, fee, 0, inputs, outputs}
+}
+
+var ErrInsufficientFunds = errors.New("unspent outputs do not cover value and fee")
+
+// SelectOutputs picks unspent outputs of sender, largest first, until they
+// cover value plus fee, and pays value to recipient with whatever is left
+// over returned to sender as change.
+func SelectOutputs(unspent []*utils.UnspentOutput, sender string, recipient string,
+	value utils.Amount, fee utils.Amount) ([]utils.OutPoint, []utils.TxOutput, error) {
+	need, err := utils.AddAmount(value, fee)
+	if err != nil {
+		return nil, nil, err
+	}
+	candidates := make([]*utils.UnspentOutput, len(unspent))
+	copy(candidates, unspent)
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return candidates[i].Output.Value > candidates[j].Output.Value
+	})
+	inputs := make([]utils.OutPoint, 0)
+	var total utils.Amount
+	for _, u := range candidates {
+		if total >= need {
+			break
+		}
+		if total, err = utils.AddAmount(total, u.Output.Value); err != nil {
+			return nil, nil, err
+		}
+		inputs = append(inputs, u.OutPoint)
+	}
+	if total < need {
+		return nil, nil, ErrInsufficientFunds
+	}
+	outputs := []utils.TxOutput{{Address: recipient, Value: value}}
+	if change := total - need; change > 0 {
+		outputs = append(outputs, utils.TxOutput{Address: sender, Value: change})
+	}
+	return inputs, outputs, nil
 }
 
+// GenerateSignature signs the canonical encoding of the transaction, the
+// same bytes the blockchain derives the transaction ID from.
 func (t *Transaction) GenerateSignature() *utils.Signature {
-	m, _ := json.Marshal(t)
-	h := sha256.Sum256([]byte(m))
+	h := utils.TransactionHash(t.senderBlockChainAddress, t.recipientBlockchainAddress, t.value, t.fee,
+		t.nonce, false, t.inputs, t.outputs)
 	r, s, _ := ecdsa.Sign(rand.Reader, t.senderPrivateKey, h[:])
-	return &utils.Signature{r, s}
+	return &utils.Signature{R: r, S: s}
 }
 
 func (t *Transaction) MarshalJSON() ([]byte, error) {
 	return json.Marshal(struct {
 		Sender    string  `json:"sender_blockchain_address"`
 		Recipient string  `json:"recipient_blockchain_address"`
-		Value     float32 `json:"value"`
+		Value     utils.Amount `json:"value"`
+		Fee       utils.Amount `json:"fee"`
+		Nonce     uint64  `json:"nonce"`
+		Inputs    []utils.OutPoint `json:"inputs,omitempty"`
+		Outputs   []utils.TxOutput `json:"outputs,omitempty"`
 	}{
 		Sender:    t.senderBlockChainAddress,
 		Recipient: t.recipientBlockchainAddress,
 		Value:     t.value,
+		Fee:       t.fee,
+		Nonce:     t.nonce,
+		Inputs:    t.inputs,
+		Outputs:   t.outputs,
 	})
 }
 
@@ -128,6 +244,7 @@ type TransactionRequest struct {
 	RecipientBlockchainAddress *string `json:"recipient_blockchain_address"`
 	SenderPublicKey            *string `json:"sender_public_key"`
 	Value                      *string `json:"value"`
+	Fee                        *string `json:"fee"`
 }
 
 func (tr *TransactionRequest) Validate() bool {
@@ -0,0 +1,68 @@
+package wallet
+
+import (
+	"errors"
+	"goblockchain/utils"
+	"reflect"
+	"testing"
+)
+
+func TestNewWalletFromPrivateKey(t *testing.T) {
+	w := NewWallet()
+	restored, err := NewWalletFromPrivateKey(w.PrivateKeyStr())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if restored.BlockChainAddress() != w.BlockChainAddress() || restored.PublicKeyStr() != w.PublicKeyStr() {
+		t.Fatalf("restored %s, want %s", restored.BlockChainAddress(), w.BlockChainAddress())
+	}
+	for _, s := range []string{"", "0", "zz", "ffffffff00000000ffffffffffffffffbce6faada7179e84f3b9cac2fc632551"} {
+		if _, err := NewWalletFromPrivateKey(s); err == nil {
+			t.Errorf("private key %q was accepted", s)
+		}
+	}
+}
+
+func TestSelectOutputs(t *testing.T) {
+	sender, recipient := NewWallet().BlockChainAddress(), NewWallet().BlockChainAddress()
+	unspent := make([]*utils.UnspentOutput, 0)
+	for i, v := range []utils.Amount{500, 2000, 1000} {
+		unspent = append(unspent, &utils.UnspentOutput{
+			OutPoint: utils.OutPoint{Index: uint32(i)},
+			Output:   utils.TxOutput{Address: sender, Value: v},
+		})
+	}
+	tests := []struct {
+		value, fee utils.Amount
+		inputs     []uint32
+		change     utils.Amount
+	}{
+		{1500, 100, []uint32{1}, 400},
+		{1900, 100, []uint32{1}, 0},
+		{2500, 100, []uint32{1, 2}, 400},
+		{3400, 100, []uint32{1, 2, 0}, 0},
+	}
+	for _, tt := range tests {
+		inputs, outputs, err := SelectOutputs(unspent, sender, recipient, tt.value, tt.fee)
+		if err != nil {
+			t.Fatalf("paying %d with fee %d: %v", tt.value, tt.fee, err)
+		}
+		picked := make([]uint32, len(inputs))
+		for i, o := range inputs {
+			picked[i] = o.Index
+		}
+		if !reflect.DeepEqual(picked, tt.inputs) {
+			t.Errorf("paying %d with fee %d spends %v, want %v", tt.value, tt.fee, picked, tt.inputs)
+		}
+		want := []utils.TxOutput{{Address: recipient, Value: tt.value}}
+		if tt.change > 0 {
+			want = append(want, utils.TxOutput{Address: sender, Value: tt.change})
+		}
+		if !reflect.DeepEqual(outputs, want) {
+			t.Errorf("paying %d with fee %d creates %v, want %v", tt.value, tt.fee, outputs, want)
+		}
+	}
+	if _, _, err := SelectOutputs(unspent, sender, recipient, 3500, 1); !errors.Is(err, ErrInsufficientFunds) {
+		t.Fatalf("paying more than the outputs hold: %v, want %v", err, ErrInsufficientFunds)
+	}
+}
@@ -2,6 +2,7 @@ package main
 
 import (
 	"flag"
+	"goblockchain/block"
 	"log"
 )
 
@@ -12,8 +13,16 @@ func init() {
 func main() {
 	port := flag.Uint("port", 8080, "TCP Port Number for Wallet Server")
 	gateway := flag.String("gateway", "http://127.0.0.1:5001", "Blockchain Gateway")
+	genesis := flag.String("genesis", "", "Chain params file of the gateway's network (default built-in params)")
 	flag.Parse()
-	app := NewWalletServer(uint16(*port), *gateway)
+	params := block.DefaultChainParams()
+	if *genesis != "" {
+		var err error
+		if params, err = block.LoadChainParams(*genesis); err != nil {
+			log.Fatalf("ERROR: %v", err)
+		}
+	}
+	app := NewWalletServer(uint16(*port), *gateway, params)
 	log.Print("Server starts, port ", *port)
 	app.Run()
 }
\ No newline at end of file
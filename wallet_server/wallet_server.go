@@ -12,16 +12,22 @@ import (
 	"io"
 	"log"
 	"net/http"
+	"net/url"
 	"strconv"
 )
 
+// DEFAULT_FEE is offered when the client does not name a fee; it covers the
+// default minimum relay fee of a typical transaction.
+const DEFAULT_FEE = "0.00001"
+
 type WalletServer struct {
 	port    uint16
 	gateway string
+	params  *block.ChainParams
 }
 
-func NewWalletServer(port uint16, gateway string) *WalletServer {
-	return &WalletServer{port, gateway}
+func NewWalletServer(port uint16, gateway string, params *block.ChainParams) *WalletServer {
+	return &WalletServer{port, gateway, params}
 }
 
 func (ws *WalletServer) Port() uint16 {
@@ -75,37 +81,103 @@ func (ws *WalletServer) CreateTransaction(w http.ResponseWriter, req *http.Reque
 				return
 			}
 
+			if err := wallet.ValidateAddress(*t.RecipientBlockchainAddress); err != nil {
+				log.Printf("ERROR: recipient: %v", err)
+				io.WriteString(w, string(utils.JsonError(err)))
+				return
+			}
+
 			publicKey := utils.PublicKeyFromString(*t.SenderPublicKey)
 			privateKey := utils.PrivateKeyFromString(*t.SenderPrivateKey, publicKey)
-			value, err := strconv.ParseFloat(*t.Value, 32)
+			decimals := ws.params.Decimals
+			value, err := utils.ParseAmount(*t.Value, decimals)
+			if err != nil {
+				log.Printf("ERROR: value: %v", err)
+				io.WriteString(w, string(utils.JsonError(err)))
+				return
+			}
+			valueStr := utils.FormatAmount(value, decimals)
+			feeStr := DEFAULT_FEE
+			if t.Fee != nil && *t.Fee != "" {
+				feeStr = *t.Fee
+			}
+			fee, err := utils.ParseAmount(feeStr, decimals)
 			if err != nil {
-				log.Println("ERROR: parse error")
+				log.Printf("ERROR: fee: %v", err)
+				io.WriteString(w, string(utils.JsonError(err)))
+				return
+			}
+			feeStr = utils.FormatAmount(fee, decimals)
+
+			ur, err := ws.unspentOutputs(*t.SenderBlockchainAddress)
+			if err != nil {
+				log.Printf("ERROR: %v", err)
+				io.WriteString(w, string(utils.JsonError(err)))
+				return
 			}
-			value32 := float32(value)		
 
 			w.Header().Add("Content-type", "application/json")
-			transaction := wallet.NewTransaction(privateKey, publicKey, *t.SenderBlockchainAddress, *t.RecipientBlockchainAddress, value32)
-			signature := transaction.GenerateSignature()
-			signatureStr := signature.String()
-
-			bt := &block.TransactionRequest{
-				t.SenderBlockchainAddress,
-				t.RecipientBlockchainAddress,
-				t.SenderPublicKey,
-				&value32, &signatureStr,
+			var bt *block.TransactionRequest
+			if ur.Ledger == block.LEDGER_UTXO {
+				inputs, outputs, err := wallet.SelectOutputs(ur.UTXOs, *t.SenderBlockchainAddress, *t.RecipientBlockchainAddress, value, fee)
+				if err != nil {
+					log.Printf("ERROR: %v", err)
+					io.WriteString(w, string(utils.JsonError(err)))
+					return
+				}
+				transaction := wallet.NewUTXOTransaction(privateKey, publicKey, *t.SenderBlockchainAddress, inputs, outputs, fee)
+				signatureStr := transaction.GenerateSignature().String()
+				outputRequests := make([]block.OutputRequest, len(outputs))
+				for i, o := range outputs {
+					outputRequests[i] = block.OutputRequest{Address: o.Address, Value: utils.FormatAmount(o.Value, decimals)}
+				}
+				var nonce uint64
+				bt = &block.TransactionRequest{
+					SenderBlockchainAddress: t.SenderBlockchainAddress,
+					SenderPublicKey:         t.SenderPublicKey,
+					Inputs:                  inputs,
+					Outputs:                 outputRequests,
+					Fee:                     &feeStr,
+					Nonce:                   &nonce,
+					Signature:               &signatureStr,
+				}
+			} else {
+				nonce, err := ws.nextNonce(*t.SenderBlockchainAddress)
+				if err != nil {
+					log.Printf("ERROR: %v", err)
+					io.WriteString(w, string(utils.JsonError(err)))
+					return
+				}
+				transaction := wallet.NewTransaction(privateKey, publicKey, *t.SenderBlockchainAddress, *t.RecipientBlockchainAddress, value, fee, nonce)
+				signature := transaction.GenerateSignature()
+				signatureStr := signature.String()
+
+				bt = &block.TransactionRequest{
+					SenderBlockchainAddress:    t.SenderBlockchainAddress,
+					RecipientBlockchainAddress: t.RecipientBlockchainAddress,
+					SenderPublicKey:            t.SenderPublicKey,
+					Value:                      &valueStr,
+					Fee:                        &feeStr,
+					Nonce:                      &nonce,
+					Signature:                  &signatureStr,
+				}
 			}
 			
 			m, _ := json.Marshal(bt)
 			buf := bytes.NewBuffer(m)
 			resp, err := http.Post(ws.Gateway() + "/transactions", "application/json", buf)
 			if err != nil {
-				fmt.Println("ERROR:" + err.Error())
+				log.Printf("ERROR: %v", err)
+				io.WriteString(w, string(utils.JsonError(err)))
+				return
 			}
+			defer resp.Body.Close()
 			if resp.StatusCode == 201 {
 				io.WriteString(w, string(utils.JsonStatus("success")))
 				return
 			}
-			io.WriteString(w, string(utils.JsonStatus("fail")))
+			// Pass the gateway's rejection reason through to the client.
+			io.Copy(w, resp.Body)
 
 		default:
 			w.WriteHeader(http.StatusBadRequest)
@@ -113,6 +185,44 @@ func (ws *WalletServer) CreateTransaction(w http.ResponseWriter, req *http.Reque
 	}
 }
 
+// unspentOutputs asks the gateway which ledger it runs and, on a UTXO
+// ledger, which outputs blockchainAddress can spend.
+func (ws *WalletServer) unspentOutputs(blockchainAddress string) (*block.UTXOResponse, error) {
+	endpoint := fmt.Sprintf("%s/utxos?blockchain_address=%s", ws.Gateway(), url.QueryEscape(blockchainAddress))
+	resp, err := http.Get(endpoint)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("utxos request failed: %s", resp.Status)
+	}
+	ur := block.UTXOResponse{Decimals: ws.params.Decimals}
+	if err := json.NewDecoder(resp.Body).Decode(&ur); err != nil {
+		return nil, err
+	}
+	return &ur, nil
+}
+
+// nextNonce asks the gateway which nonce the next transaction from
+// blockchainAddress has to carry.
+func (ws *WalletServer) nextNonce(blockchainAddress string) (uint64, error) {
+	endpoint := fmt.Sprintf("%s/nonce?blockchain_address=%s", ws.Gateway(), url.QueryEscape(blockchainAddress))
+	resp, err := http.Get(endpoint)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return 0, fmt.Errorf("nonce request failed: %s", resp.Status)
+	}
+	var nr block.NonceResponse
+	if err := json.NewDecoder(resp.Body).Decode(&nr); err != nil {
+		return 0, err
+	}
+	return nr.Nonce, nil
+}
+
 func (ws *WalletServer) WalletAmount (w http.ResponseWriter, req *http.Request) {
 	switch req.Method{
 	case http.MethodGet:
@@ -148,7 +258,7 @@ func (ws *WalletServer) WalletAmount (w http.ResponseWriter, req *http.Request)
 			}
 				m, _ := json.Marshal(struct{
 					Message string `json:"message"`
-					Amount  float32 `json:"amount"`
+					Amount  string `json:"amount"`
 				}{
 					Message: "success",
 					Amount: bar.Amount,